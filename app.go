@@ -2,7 +2,13 @@ package cache
 
 import (
 	"errors"
+	"fmt"
+	"math/rand"
 	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	keymanager "github.com/manhcuongincusar1/pointer-cache/key_manager"
 )
@@ -13,46 +19,242 @@ func New(option *Option, initData map[string]*Item) (*Cache, error) {
 		return nil, errors.New("memory limit is required")
 	}
 
-	_cache, err := newCacheWithJanitor(option, initData)
+	if option.MemoryLimit < 0 && option.MemoryLimit != Unlimited {
+		return nil, errors.New("memory limit must be positive or Unlimited")
+	}
+
+	if option.KeyManagerCapacity < 0 {
+		return nil, errors.New("key manager capacity must not be negative")
+	}
+
+	// keymanager
+	keyManager, err := keymanager.NewKeyManager(option.KeyManagerType, uint32(option.KeyManagerCapacity))
 	if err != nil {
 		return nil, err
 	}
+	if option.KeyManagerType == keymanager.Decay && option.DecayHalfLife > 0 {
+		keyManager = keymanager.NewDecay(option.DecayHalfLife)
+	}
+	if grower, ok := keyManager.(interface{ Grow(n int) }); ok && option.ExpectedItems > 0 {
+		grower.Grow(option.ExpectedItems)
+	}
 
-	// keymanager
-	keyManager, err := keymanager.NewKeyManager(option.KeyManagerType, 0)
+	_cache, err = newCacheWithJanitor(option, keyManager, initData)
 	if err != nil {
 		return nil, err
 	}
-	_cache.keyManager = keyManager
 
 	return &Cache{
-		_cache,
+		cache: _cache,
 	}, nil
 }
 
+// NewFromMap creates a cache pre-populated with the values of m, each
+// expiring after the option's DefaultExpiration. Sizes of large inputs are
+// computed in parallel.
+func NewFromMap[V any](m map[string]V, option *Option) (*Cache, error) {
+	var e int64
+	if option.DefaultExpiration > 0 {
+		e = time.Now().Add(option.DefaultExpiration).UnixNano()
+	}
+
+	initData := make(map[string]*Item, len(m))
+	for k, v := range m {
+		initData[k] = &Item{
+			Object:     v,
+			Expiration: e,
+		}
+	}
+
+	return New(option, initData)
+}
+
 type Cache struct {
 	*cache
 }
 
 func newCache(option *Option, m map[string]*Item) *cache {
 	if m == nil {
-		m = make(map[string]*Item)
+		m = make(map[string]*Item, option.ExpectedItems)
 	}
 
 	c := &cache{
-		option: option,
-		items:  m,
+		cacheState: &cacheState{
+			option: option,
+			items:  m,
+		},
+	}
+	c.expirations.tiebreak = option.Tiebreak
+
+	if option.EventBufferSize > 0 {
+		c.events.ring = newRing[Event](option.EventBufferSize)
+	}
+
+	if option.EvictionTraceSize > 0 {
+		c.trace = newRing[EvictionDecision](option.EvictionTraceSize)
+	}
+
+	for prefix, ttl := range option.TTLRules {
+		if c.ttlRules == nil {
+			c.ttlRules = make(TTLRules, len(option.TTLRules))
+		}
+		c.ttlRules[prefix] = ttl
+	}
+
+	for prefix, ttl := range option.SoftTTLRules {
+		if c.softTTLRules == nil {
+			c.softTTLRules = make(TTLRules, len(option.SoftTTLRules))
+		}
+		c.softTTLRules[prefix] = ttl
+	}
+
+	if option.DeterministicSeed != 0 {
+		c.rng = rand.New(rand.NewSource(option.DeterministicSeed))
+	}
+
+	if option.MaxSetsPerSecond > 0 {
+		c.rateLimit = newTokenBucket(option.MaxSetsPerSecond)
+	}
+
+	if len(option.Quota) > 0 {
+		c.quotaHits = make(map[string]*hitCounters, len(option.Quota))
+		for prefix := range option.Quota {
+			c.quotaHits[prefix] = &hitCounters{}
+		}
 	}
 
 	return c
 }
 
-func newCacheWithJanitor(option *Option, m map[string]*Item) (*cache, error) {
-	c := newCache(option, m)
+func newCacheWithJanitor(option *Option, keyManager keymanager.KeyManager, initData map[string]*Item) (*cache, error) {
+	size := option.ExpectedItems
+	if len(initData) > size {
+		size = len(initData)
+	}
+
+	c := newCache(option, make(map[string]*Item, size))
+	c.WithKeyManager(keyManager)
+
+	// Initial items go through the same accounting as Set before the janitor starts
+	if err := c.admit(initData); err != nil {
+		return nil, err
+	}
+
+	if option.TimeResolution > 0 {
+		runClock(c, option.TimeResolution)
+	}
+
 	if option.CleanupInterval > 0 {
 		runJanitor(c, option.CleanupInterval)
-		runtime.SetFinalizer(c, stopJanitor)
+	}
+
+	if c.janitor != nil || c.clock != nil {
+		runtime.SetFinalizer(c, stopBackground)
 	}
 
 	return c, nil
 }
+
+// stopBackground stops the goroutines started for c
+func stopBackground(c *cache) {
+	if c.janitor != nil {
+		stopJanitor(c)
+	}
+
+	if c.clock != nil {
+		stopClock(c)
+	}
+}
+
+// admit registers initial items: expired ones are dropped, the rest are
+// measured and added to the key manager in key order. It fails rather than
+// evicting when the data doesn't fit in Capacity or MemoryLimit.
+func (p *cache) admit(initData map[string]*Item) error {
+	keys := make([]string, 0, len(initData))
+	for k, item := range initData {
+		if item == nil {
+			return fmt.Errorf("initial item %s is nil", k)
+		}
+
+		if item.Expired() {
+			continue
+		}
+
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if p.option.Capacity > 0 && len(keys) > p.option.Capacity {
+		return fmt.Errorf("initial data has %d items, over the capacity of %d", len(keys), p.option.Capacity)
+	}
+
+	// The cache owns its Items and recycles them, so the caller's are copied
+	items := make(map[string]*Item, len(keys))
+	for _, k := range keys {
+		item := p.itemPool.get()
+		*item = *initData[k]
+		items[k] = item
+	}
+
+	now := time.Now().UnixNano()
+	p.measure(keys, items)
+	for _, k := range keys {
+		item := items[k]
+		if item.Updated == 0 {
+			item.Updated = now
+		}
+		p.items[k] = item
+		atomic.AddInt64(&p.count, 1)
+		p.addMemUsage(k, item.Mem)
+		p.trackKey(k)
+		p.scheduleExpiration(k, item.Expiration)
+	}
+	p.peakItems = len(p.items)
+
+	if p.option.MemoryLimit > 0 && p.memUsage > p.option.MemoryLimit {
+		return fmt.Errorf("initial data uses %d bytes, over the memory limit of %d", p.memUsage, p.option.MemoryLimit)
+	}
+
+	for prefix, usage := range p.quotaUsage {
+		if usage > p.option.Quota[prefix] {
+			return fmt.Errorf("initial data uses %d bytes under %s, over its quota of %d", usage, prefix, p.option.Quota[prefix])
+		}
+	}
+
+	return nil
+}
+
+// parallelMeasureThreshold is the number of initial items from which sizes
+// are computed by one goroutine per P instead of sequentially
+const parallelMeasureThreshold = 1024
+
+// measure fills in Mem for the given items
+func (p *cache) measure(keys []string, items map[string]*Item) {
+	if len(keys) < parallelMeasureThreshold {
+		for _, k := range keys {
+			items[k].Mem = p.calculateItemSize(k, items[k].Object)
+		}
+
+		return
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	chunk := (len(keys) + workers - 1) / workers
+
+	var wg sync.WaitGroup
+	for start := 0; start < len(keys); start += chunk {
+		end := start + chunk
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		wg.Add(1)
+		go func(keys []string) {
+			defer wg.Done()
+			for _, k := range keys {
+				items[k].Mem = p.calculateItemSize(k, items[k].Object)
+			}
+		}(keys[start:end])
+	}
+	wg.Wait()
+}
@@ -0,0 +1,32 @@
+package cache
+
+import "time"
+
+// budgetCheckInterval is how many evictions go by between two looks at the
+// clock, so that keeping to a budget doesn't cost a time.Now per item
+const budgetCheckInterval = 64
+
+// budget bounds the time spent in one eviction pass to Option.EvictionBudget
+type budget struct {
+	deadline time.Time
+	n        int
+}
+
+func (p *cache) newBudget() *budget {
+	b := &budget{}
+	if p.option.EvictionBudget > 0 {
+		b.deadline = time.Now().Add(p.option.EvictionBudget)
+	}
+
+	return b
+}
+
+// spent counts one eviction and reports whether the pass should stop
+func (b *budget) spent() bool {
+	b.n++
+	if b.deadline.IsZero() || b.n%budgetCheckInterval != 0 {
+		return false
+	}
+
+	return time.Now().After(b.deadline)
+}
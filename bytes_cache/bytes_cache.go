@@ -0,0 +1,159 @@
+package bytescache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cache "github.com/manhcuongincusar1/pointer-cache"
+)
+
+type Option struct {
+	// Pool recycles the buffers of deleted, expired and replaced values
+	// through Buffer. A slice returned by Get must then not be used once its
+	// key may have been written or deleted; use AppendTo to keep a copy.
+	Pool bool
+
+	// ChunkSize splits values longer than this many bytes into chunks
+	// stored under keys of their own and put back together by Get, so that
+	// one huge value is evicted piece by piece. Evicting any chunk makes
+	// the whole value a miss. Zero disables it.
+	ChunkSize int
+}
+
+// BytesCache stores []byte values, e.g. serialized payloads or file chunks.
+// Values are charged their capacity, the memory they actually hold, without
+// being measured.
+type BytesCache struct {
+	cache  *cache.Cache
+	option Option
+	pool   sync.Pool
+	gen    uint64 // see chunked
+}
+
+// New creates a BytesCache on top of c. With Option.Pool it takes over the
+// OnEvicted and OnReplaced callbacks of c.
+func New(c *cache.Cache, option Option) *BytesCache {
+	p := &BytesCache{
+		cache:  c,
+		option: option,
+	}
+
+	if option.Pool {
+		c.OnEvicted(func(_ string, v interface{}) { p.recycle(v) })
+		c.OnReplaced(func(_ string, previous, v interface{}) {
+			// Writing back the slice Get returned keeps its buffer in use
+			if !sharesArray(previous, v) {
+				p.recycle(previous)
+			}
+		})
+	}
+
+	return p
+}
+
+// Get returns the value of k and whether an unexpired value was found.
+// The returned slice is shared with the cache and must not be modified,
+// unless the value was chunked, see Option.ChunkSize.
+func (p *BytesCache) Get(k string) ([]byte, bool) {
+	v, found := p.cache.Get(k)
+	if !found {
+		return nil, false
+	}
+
+	if c, ok := v.(chunked); ok {
+		return p.appendChunks(nil, k, c)
+	}
+
+	b, ok := v.([]byte)
+	return b, ok
+}
+
+// AppendTo appends the value of k to dst, returning dst unchanged and false
+// if no unexpired value was found. The value is copied under the cache's
+// lock, so the copy is safe to keep even with Option.Pool.
+func (p *BytesCache) AppendTo(dst []byte, k string) ([]byte, bool) {
+	var (
+		c         chunked
+		isChunked bool
+		ok        bool
+	)
+	found := p.cache.Read(k, func(v interface{}) {
+		switch v := v.(type) {
+		case []byte:
+			dst, ok = append(dst, v...), true
+		case chunked:
+			c, isChunked = v, true
+		}
+	})
+	if found && isChunked {
+		return p.appendChunks(dst, k, c)
+	}
+
+	return dst, ok
+}
+
+// Set stores v under k for d, see cache.Set. The cache takes ownership of
+// v, which must not be modified afterwards.
+func (p *BytesCache) Set(k string, v []byte, d time.Duration) error {
+	if p.option.ChunkSize > 0 && len(v) > p.option.ChunkSize {
+		return p.setChunked(k, v, d)
+	}
+
+	// The chunks of a chunked value replaced by a concurrent write may be
+	// left for eviction to clean up
+	var previous interface{}
+	if p.option.ChunkSize > 0 {
+		previous, _ = p.cache.Get(k)
+	}
+	if err := p.cache.SetWithSize(k, v, int64(cap(v)), d); err != nil {
+		return err
+	}
+	p.dropChunks(k, previous)
+
+	return nil
+}
+
+// Delete removes k
+func (p *BytesCache) Delete(k string) {
+	v, _ := p.cache.GetAndDelete(k)
+	p.dropChunks(k, v)
+}
+
+// Buffer returns an empty slice with room for at least n bytes, reusing a
+// recycled buffer when Option.Pool is set, to be filled and passed to Set
+func (p *BytesCache) Buffer(n int) []byte {
+	if v := p.pool.Get(); v != nil {
+		if b := *v.(*[]byte); cap(b) >= n {
+			return b[:0]
+		}
+	}
+
+	return make([]byte, 0, n)
+}
+
+func (p *BytesCache) nextGen() uint64 {
+	return atomic.AddUint64(&p.gen, 1)
+}
+
+// sharesArray reports whether a and b are slices over the same array, which
+// their last elements within capacity tell
+func sharesArray(a, b interface{}) bool {
+	x, ok := a.([]byte)
+	if !ok || cap(x) == 0 {
+		return false
+	}
+
+	y, ok := b.([]byte)
+	if !ok || cap(y) == 0 {
+		return false
+	}
+
+	return &x[:cap(x)][cap(x)-1] == &y[:cap(y)][cap(y)-1]
+}
+
+func (p *BytesCache) recycle(v interface{}) {
+	if b, ok := v.([]byte); ok && cap(b) > 0 {
+		p.pool.Put(&b)
+	}
+}
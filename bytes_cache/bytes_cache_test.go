@@ -0,0 +1,163 @@
+package bytescache
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	cache "github.com/manhcuongincusar1/pointer-cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBytesCache(t *testing.T) {
+	c, err := cache.New(&cache.Option{MemoryLimit: 222222}, nil)
+	assert.Nil(t, err)
+
+	bc := New(c, Option{})
+	assert.Nil(t, bc.Set("chunk", []byte("payload"), cache.NoExpiration))
+
+	v, found := bc.Get("chunk")
+	assert.True(t, found)
+	assert.Equal(t, "payload", string(v))
+
+	dst, found := bc.AppendTo([]byte("> "), "chunk")
+	assert.True(t, found)
+	assert.Equal(t, "> payload", string(dst))
+
+	// Sizes follow the capacity of the value
+	alloc := c.Alloc()
+	assert.Nil(t, bc.Set("chunk", make([]byte, 10, 1024), cache.NoExpiration))
+	assert.Equal(t, alloc+1024-int64(cap(v)), c.Alloc())
+
+	bc.Delete("chunk")
+	_, found = bc.Get("chunk")
+	assert.False(t, found)
+}
+
+func TestBytesCachePool(t *testing.T) {
+	c, err := cache.New(&cache.Option{MemoryLimit: 222222}, nil)
+	assert.Nil(t, err)
+
+	bc := New(c, Option{Pool: true})
+
+	buf := append(bc.Buffer(64), "first"...)
+	assert.Nil(t, bc.Set("a", buf, cache.NoExpiration))
+
+	// Replacing the value recycles its buffer
+	assert.Nil(t, bc.Set("a", []byte("second"), cache.NoExpiration))
+	reused := bc.Buffer(32)
+	assert.Equal(t, 0, len(reused))
+	assert.GreaterOrEqual(t, cap(reused), 32)
+
+	// Buffers too small are not handed out
+	bc.Delete("a")
+	assert.GreaterOrEqual(t, cap(bc.Buffer(4096)), 4096)
+
+	// Writing back the slice Get returned doesn't recycle it
+	assert.Nil(t, bc.Set("b", []byte("hello"), cache.NoExpiration))
+	v, _ := bc.Get("b")
+	assert.Nil(t, bc.Set("b", v, cache.NoExpiration))
+	_ = append(bc.Buffer(5), "XXXXX"...)
+	v, _ = bc.Get("b")
+	assert.Equal(t, "hello", string(v))
+}
+
+func TestBytesCacheChunks(t *testing.T) {
+	c, err := cache.New(&cache.Option{MemoryLimit: 222222}, nil)
+	assert.Nil(t, err)
+
+	bc := New(c, Option{ChunkSize: 4})
+	assert.Nil(t, bc.Set("big", []byte("0123456789"), cache.NoExpiration))
+	assert.Equal(t, 4, c.Size())
+
+	v, found := bc.Get("big")
+	assert.True(t, found)
+	assert.Equal(t, "0123456789", string(v))
+
+	dst, found := bc.AppendTo([]byte("> "), "big")
+	assert.True(t, found)
+	assert.Equal(t, "> 0123456789", string(dst))
+
+	// Replacing the value drops its chunks
+	assert.Nil(t, bc.Set("big", []byte("small"), cache.NoExpiration))
+	assert.Equal(t, 3, c.Size())
+	assert.Nil(t, bc.Set("big", []byte("ab"), cache.NoExpiration))
+	assert.Equal(t, 1, c.Size())
+	v, _ = bc.Get("big")
+	assert.Equal(t, "ab", string(v))
+
+	// A value missing a chunk is a miss
+	assert.Nil(t, bc.Set("big", []byte("0123456789"), cache.NoExpiration))
+	c.ForEach(func(k string, _ interface{}) bool {
+		if k != "big" {
+			c.Delete(k)
+			return false
+		}
+		return true
+	})
+	_, found = bc.Get("big")
+	assert.False(t, found)
+	assert.Equal(t, 1, c.Size())
+
+	bc.Delete("big")
+	assert.Equal(t, 0, c.Size())
+}
+
+func TestBytesCacheChunksWithPool(t *testing.T) {
+	c, err := cache.New(&cache.Option{MemoryLimit: 222222}, nil)
+	assert.Nil(t, err)
+
+	bc := New(c, Option{Pool: true, ChunkSize: 4})
+
+	// Chunks recycled by writers are never read mid-copy
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func(fill byte) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				bc.Set("big", bytes.Repeat([]byte{fill}, 16), cache.NoExpiration)
+				bc.Delete("big")
+			}
+		}('a' + byte(w))
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				if got, found := bc.Get("big"); found {
+					assert.Equal(t, bytes.Repeat(got[:1], 16), got)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestBytesCacheAppendToWithPool(t *testing.T) {
+	c, err := cache.New(&cache.Option{MemoryLimit: 222222}, nil)
+	assert.Nil(t, err)
+
+	bc := New(c, Option{Pool: true})
+
+	// AppendTo copies before a recycled buffer can be written again
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func(fill byte) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				buf := append(bc.Buffer(16), bytes.Repeat([]byte{fill}, 16)...)
+				bc.Set("a", buf, cache.NoExpiration)
+				bc.Delete("a")
+			}
+		}('a' + byte(w))
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				if got, found := bc.AppendTo(nil, "a"); found {
+					assert.Equal(t, bytes.Repeat(got[:1], 16), got)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
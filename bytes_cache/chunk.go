@@ -0,0 +1,93 @@
+package bytescache
+
+import (
+	"strconv"
+	"time"
+)
+
+// chunked is stored under the key of a value split by Option.ChunkSize in
+// place of the value, whose chunks are stored under keys of their own.
+// Every write has its own generation, so the chunks of two writes of a key
+// never mix.
+type chunked struct {
+	gen    uint64
+	n      int
+	length int
+}
+
+// keys returns the keys of the chunks of the value of k, in order
+func (c chunked) keys(k string) []string {
+	keys := make([]string, c.n)
+	for i := range keys {
+		keys[i] = k + "\x00" + strconv.FormatUint(c.gen, 10) + "\x00" + strconv.Itoa(i)
+	}
+
+	return keys
+}
+
+// setChunked stores v under k as chunks of at most Option.ChunkSize bytes,
+// each copied into a buffer of its own so that evicting it frees it
+func (p *BytesCache) setChunked(k string, v []byte, d time.Duration) error {
+	size := p.option.ChunkSize
+	c := chunked{
+		gen:    p.nextGen(),
+		n:      (len(v) + size - 1) / size,
+		length: len(v),
+	}
+
+	keys := c.keys(k)
+	for i, ck := range keys {
+		end := (i + 1) * size
+		if end > len(v) {
+			end = len(v)
+		}
+
+		chunk := append(p.Buffer(end-i*size), v[i*size:end]...)
+		if err := p.cache.SetWithSize(ck, chunk, int64(cap(chunk)), d); err != nil {
+			p.cache.DeleteMulti(keys[:i])
+			return err
+		}
+	}
+
+	previous, _, err := p.cache.Swap(k, c, d)
+	if err != nil {
+		p.cache.DeleteMulti(keys)
+		return err
+	}
+	p.dropChunks(k, previous)
+	if p.option.Pool {
+		p.recycle(v)
+	}
+
+	return nil
+}
+
+// appendChunks appends the chunks of the value of k to dst. They are copied
+// under the cache's lock, as a deleted chunk may be recycled. A chunk may
+// have been evicted on its own: the value is then a miss, and its other
+// chunks are dropped.
+func (p *BytesCache) appendChunks(dst []byte, k string, c chunked) ([]byte, bool) {
+	if dst == nil {
+		dst = make([]byte, 0, c.length)
+	}
+
+	keys := c.keys(k)
+	n := len(dst)
+	found := p.cache.ReadMulti(keys, func(_ string, v interface{}) {
+		dst = append(dst, v.([]byte)...)
+	})
+	if found < len(keys) {
+		p.cache.DeleteMulti(keys)
+		return dst[:n], false
+	}
+
+	return dst, true
+}
+
+// dropChunks deletes the chunks of v, the value that was stored under k,
+// if it was chunked
+func (p *BytesCache) dropChunks(k string, v interface{}) {
+	if c, ok := v.(chunked); ok {
+		p.cache.DeleteMulti(c.keys(k))
+	}
+}
@@ -1,9 +1,12 @@
 package cache
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	keymanager "github.com/manhcuongincusar1/pointer-cache/key_manager"
@@ -16,6 +19,9 @@ const (
 	// passing in the same expiration duration as was given to New() or
 	// NewFrom() when the cache was created (e.g. 5 minutes.)
 	ZeroExpiration time.Duration = 0
+	// DefaultExpiration is ZeroExpiration under the name go-cache uses, for
+	// code migrating from it.
+	DefaultExpiration = ZeroExpiration
 
 	// Pointer Size
 	// If system 32 bit/8 = 4 bytes
@@ -32,6 +38,8 @@ type Item struct {
 	Object     any
 	Expiration int64
 	Mem        int64
+	Updated    int64 // Unix nano time of the last write
+	Fresh      int64 // Unix nano time the item turns stale, 0 if it is fresh until it expires
 }
 
 // Returns true if the item has expired.
@@ -43,18 +51,63 @@ func (item Item) Expired() bool {
 }
 
 type cache struct {
+	*cacheState
+
+	label *labelCounters // see WithLabel
+	root  *cache         // the cache a labeled handle was made from, kept reachable for its finalizer
+}
+
+// cacheState is what a cache shares with the handles returned by WithLabel
+type cacheState struct {
 	option     *Option
 	items      map[string]*Item
 	mu         sync.RWMutex
 	onEvicted  func(string, any)
+	onSet      func(string, any)
+	onReplaced func(string, any, any)
 	janitor    *janitor
-	memUsage   int64
+	clock      *clock
+	memUsage   int64 // written atomically under the lock, read lock-free by Alloc
+	count      int64 // len(items), maintained the same way for Size
 	keyManager keymanager.KeyManager
+	accessor   keymanager.Accessor // keyManager, if it ranks keys by reads
+	itemPool   itemPool
+	quotaUsage map[string]int64
+	tombstones map[string]int64
+	leases     map[string]lease
+	leaseSeq   uint64
+	peakItems  int
+	latency    latencies
+
+	events       eventRing
+	trace        ring[EvictionDecision]  // see EvictionTrace
+	quotaHits    map[string]*hitCounters // fixed at creation, see countLookup
+	rateLimit    *tokenBucket            // see Option.MaxSetsPerSecond
+	labels       map[string]*labelCounters
+	ttlRules     TTLRules
+	softTTLRules TTLRules
+	refreshes    map[string]*refresh
+	rng          *rand.Rand // see random
+
+	slowEvicted  int64 // see callOnEvicted
+	evictedAsync int32
+
+	// expirations orders the items that expire, indexed by key
+	expirations     expirationHeap
+	expirationIndex map[string]*expirationEntry
+	expireHooks     map[string][]func() // see ScheduleOnExpire
+
+	onEvictedBatch func([]KV, EvictionReason)
+	evictions      []evictionBatch // recorded for onEvictedBatch, see takeEvictions
+	expired        []keyAndValue   // removed by a write for OnEvicted, see takeEvictions
+
+	frozen *frozen // see FreezeEviction
 }
 
-// Alloc allows to expose used memory as bytes
+// Alloc allows to expose used memory as bytes.
+// It doesn't take the lock, so it is cheap to poll.
 func (p *cache) Alloc() int64 {
-	return p.memUsage
+	return atomic.LoadInt64(&p.memUsage)
 }
 
 // WithKeyManager allows consumer side (Developer) to add their own implement in developmet time
@@ -62,17 +115,53 @@ func (p *cache) Alloc() int64 {
 // All need to do is implement keymanager.KeyManager
 func (p *cache) WithKeyManager(manager keymanager.KeyManager) {
 	p.keyManager = manager
+	p.accessor, _ = manager.(keymanager.Accessor)
+}
+
+// access tells a key manager ranking keys by reads that k was read
+func (p *cache) access(k string) {
+	if p.accessor != nil {
+		p.accessor.Access(k)
+	}
 }
 
 // Add an item to the cache, replacing any existing item. If the duration is 0
 // (DefaultExpiration), the cache's default expiration time is used. If it is -1
 // (NoExpiration), the item never expires.
 func (p *cache) Set(k string, v interface{}, d time.Duration) error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	if p.option.TrackLatency {
+		defer p.track(&p.latency.set, time.Now())
+	}
 
 	// Set data
-	return p.set(k, v, d)
+	_, _, err := p.write(k, v, d, nil, nil)
+	return err
+}
+
+// Swap stores an item like Set and returns the value it replaced, with a bool
+// indicating whether an unexpired item was there, in one atomic step.
+func (p *cache) Swap(k string, v interface{}, d time.Duration) (interface{}, bool, error) {
+	return p.write(k, v, d, nil, nil)
+}
+
+// SetWithSize stores an item like Set, charging size bytes for the value
+// instead of measuring it, for callers who already know it (e.g. the len of
+// a []byte). MemoryLimit and MaxItemSize still apply.
+func (p *cache) SetWithSize(k string, v interface{}, size int64, d time.Duration) error {
+	if size < 0 {
+		return fmt.Errorf("invalid size %d for %s", size, k)
+	}
+
+	_, _, err := p.write(k, v, d, nil, &writeOptions{sized: true, size: size})
+	return err
+}
+
+// SetUnsized stores an item like Set but charges a flat size for the value,
+// Option.UnsizedItemSize, instead of measuring it with DeepSize. Use it for
+// large object graphs where reflection is too slow for the hot path.
+func (p *cache) SetUnsized(k string, v interface{}, d time.Duration) error {
+	_, _, err := p.write(k, v, d, nil, &writeOptions{unsized: true})
+	return err
 }
 
 // Add an item to the cache, replacing any existing item, using the default
@@ -84,89 +173,218 @@ func (p *cache) SetDefault(k string, x interface{}) {
 // Add an item to the cache only if an item doesn't already exist for the given
 // key, or if the existing item has expired. Returns an error otherwise.
 func (p *cache) Add(k string, x interface{}, d time.Duration) error {
+	_, _, err := p.write(k, x, d, func() error {
+		_, found := p.get(k)
+		if found {
+			return fmt.Errorf("Item %s already exists", k)
+		}
+
+		return nil
+	}, nil)
+
+	return err
+}
+
+// Delete an item from the cache. Does nothing if the key is not in the cache.
+func (p *cache) Delete(k string) {
 	p.mu.Lock()
-	defer p.mu.Unlock()
 
+	v, evicted := p.deleteKey(k)
+	onEvicted := p.onEvicted
+	evictions := p.takeEvictions()
+	p.mu.Unlock()
+
+	p.fireEvictions(evictions)
+	if evicted {
+		p.callOnEvicted(onEvicted, k, v)
+	}
+}
+
+// deleteKey is delete for an explicit Delete, leaving a tombstone and
+// recording the event
+func (p *cache) deleteKey(k string) (interface{}, bool) {
+	if _, found := p.items[k]; found {
+		p.bury(k)
+		p.events.record(EventDelete, k)
+		p.recordEviction(EvictionDeleted, k)
+	}
+	delete(p.leases, k)
+
+	return p.delete(k)
+}
+
+// GetAndDelete removes an item from the cache and returns it, with a bool
+// indicating whether an unexpired item was found. OnEvicted is called as it
+// would be for Delete.
+func (p *cache) GetAndDelete(k string) (interface{}, bool) {
+	p.mu.Lock()
 	_, found := p.get(k)
-	if found {
-		return fmt.Errorf("Item %s already exists", k)
+	v, evicted := p.deleteKey(k)
+	onEvicted := p.onEvicted
+	evictions := p.takeEvictions()
+	p.mu.Unlock()
+
+	p.fireEvictions(evictions)
+
+	if evicted {
+		p.callOnEvicted(onEvicted, k, v)
+	}
+
+	if !found {
+		return nil, false
 	}
 
-	return p.set(k, x, d)
+	return v, true
 }
 
-// Delete an item from the cache. Does nothing if the key is not in the cache.
-func (p *cache) Delete(k string) {
+// Expire marks an item as expired right away without removing it, so it stops
+// being returned by Get and is cleaned up by DeleteExpired or the janitor like
+// any other expired item. Returns false if no unexpired item was found.
+func (p *cache) Expire(k string) bool {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	v, evicted := p.delete(k)
-	if evicted {
-		p.onEvicted(k, v)
+	item, found := p.getItem(k)
+	if !found {
+		return false
 	}
+
+	// Reads compare against the coarse clock, which may lag behind
+	e := time.Now().UnixNano()
+	if now := p.now(); now < e {
+		e = now
+	}
+	item.Expiration = e - 1
+	p.scheduleExpiration(k, item.Expiration)
+	return true
 }
 
 // Delete all expired items from the cache.
 func (p *cache) DeleteExpired() {
-	var evictedItems []keyAndValue
-	now := time.Now().UnixNano()
-	p.mu.Lock()
-	for k, v := range p.items {
-		// "Inlining" of expired
-		if v.Expiration > 0 && now > v.Expiration {
-			ov, evicted := p.delete(k)
-			if evicted {
-				evictedItems = append(evictedItems, keyAndValue{k, ov})
-			}
-		}
+	p.deleteExpiredPass()
+}
+
+// deleteExpiredPass is DeleteExpired returning how many items it removed
+func (p *cache) deleteExpiredPass() int {
+	if p.option.TrackLatency {
+		defer p.track(&p.latency.eviction, time.Now())
 	}
+
+	p.mu.Lock()
+	evictedItems, removed := p.deleteExpired(time.Now().UnixNano(), p.newBudget())
+	evictions := p.takeEvictions()
 	p.mu.Unlock()
+
+	p.fireEvictions(evictions)
 	for _, v := range evictedItems {
-		p.onEvicted(v.key, v.value)
+		p.callOnEvicted(p.onEvicted, v.key, v.value)
 	}
+
+	return removed
 }
 
 // Get an item from the cache. Returns the item or nil, and a bool indicating
 // whether the key was found.
 func (p *cache) Get(k string) (interface{}, bool) {
+	if p.option.TrackLatency {
+		defer p.track(&p.latency.get, time.Now())
+	}
+
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	// "Inlining" of get and Expired
 	item, found := p.items[k]
 	if !found {
+		p.countLookup(k, false)
 		return nil, false
 	}
 	if item.Expiration > 0 {
-		if time.Now().UnixNano() > item.Expiration {
+		if p.now() > item.Expiration {
+			p.countLookup(k, false)
 			return nil, false
 		}
 	}
 
+	p.countLookup(k, true)
+	p.access(k)
 	return item.Object, true
 }
 
-// Set a new value for the cache key only if it already exists, and the existing
-// item hasn't expired. Returns an error otherwise.
-func (p *cache) Replace(k string, x interface{}, d time.Duration) error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+// Read calls fn with the unexpired value of k under the read lock, and
+// reports whether it was found. Use it to copy out of a value that may be
+// reused once it leaves the cache; fn must not call the cache.
+func (p *cache) Read(k string, fn func(v interface{})) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 
 	item, found := p.getItem(k)
+	p.countLookup(k, found)
 	if !found {
-		return fmt.Errorf("Item %s doesn't exist", k)
+		return false
 	}
 
-	// Deduct mem usage
-	p.deductMemUsage(item.Mem)
-	if err := p.set(k, x, d); err != nil {
-		return err
+	p.access(k)
+	fn(item.Object)
+	return true
+}
+
+// Has reports whether k is in the cache, including an expired item that
+// hasn't been cleaned up yet, without returning its value
+func (p *cache) Has(k string) bool {
+	p.mu.RLock()
+	_, found := p.items[k]
+	p.mu.RUnlock()
+
+	return found
+}
+
+// HasUnexpired reports whether k has an unexpired item, like Get does,
+// without returning its value
+func (p *cache) HasUnexpired(k string) bool {
+	p.mu.RLock()
+	_, found := p.getItem(k)
+	p.mu.RUnlock()
+
+	return found
+}
+
+// ForEach calls fn with every unexpired item, as of the call, until fn
+// returns false. The items are copied out under the read lock, which is
+// released before fn is called, so fn may use the cache and each key is
+// visited exactly once whatever writes happen meanwhile. Values are shared,
+// not copied. The order is unspecified.
+func (p *cache) ForEach(fn func(k string, v interface{}) bool) {
+	p.mu.RLock()
+	now := p.now()
+	snapshot := make([]keyAndValue, 0, len(p.items))
+	for k, item := range p.items {
+		if item.Expiration > 0 && now > item.Expiration {
+			continue
+		}
+		snapshot = append(snapshot, keyAndValue{k, item.Object})
 	}
+	p.mu.RUnlock()
 
-	// Bring the key to last of the queue
-	p.keyManager.Delete(k)
-	p.keyManager.Add(k)
+	for _, kv := range snapshot {
+		if !fn(kv.key, kv.value) {
+			return
+		}
+	}
+}
 
-	return nil
+// Set a new value for the cache key only if it already exists, and the existing
+// item hasn't expired. Returns an error otherwise.
+func (p *cache) Replace(k string, x interface{}, d time.Duration) error {
+	_, _, err := p.write(k, x, d, func() error {
+		_, found := p.getItem(k)
+		if !found {
+			return fmt.Errorf("Item %s doesn't exist", k)
+		}
+
+		return nil
+	}, nil)
+
+	return err
 }
 
 // GetWithExpiration returns an item and its expiration time from the cache.
@@ -179,13 +397,17 @@ func (p *cache) GetWithExpiration(k string) (interface{}, time.Time, bool) {
 	// "Inlining" of get and Expired
 	item, found := p.items[k]
 	if !found {
+		p.countLookup(k, false)
 		return nil, time.Time{}, false
 	}
 
 	if item.Expiration > 0 {
-		if time.Now().UnixNano() > item.Expiration {
+		if p.now() > item.Expiration {
+			p.countLookup(k, false)
 			return nil, time.Time{}, false
 		}
+		p.countLookup(k, true)
+		p.access(k)
 
 		// Return the item and the expiration time
 		return item.Object, time.Unix(0, item.Expiration), true
@@ -193,13 +415,65 @@ func (p *cache) GetWithExpiration(k string) (interface{}, time.Time, bool) {
 
 	// If expiration <= 0 (i.e. no expiration time set) then return the item
 	// and a zeroed time.Time
+	p.countLookup(k, true)
+	p.access(k)
 	return item.Object, time.Time{}, true
 }
 
+// Flush removes all items from the cache without calling OnEvicted.
 func (p *cache) Flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	p.items = make(map[string]*Item)
+	p.quotaUsage = nil
+	p.expirations.entries = nil
+	p.expirationIndex = nil
+	p.expireHooks = nil
+	p.events.record(EventFlush, "")
+	atomic.StoreInt64(&p.count, 0)
+	atomic.StoreInt64(&p.memUsage, 0)
+
+	if clearer, ok := p.keyManager.(interface{ Clear() }); ok {
+		clearer.Clear()
+	}
+	if p.frozen != nil {
+		p.frozen.held = make(map[string]bool)
+	}
+}
+
+// FlushOlderThan removes the items last written more than d ago, without
+// calling OnEvicted like Flush, and returns how many were removed
+func (p *cache) FlushOlderThan(d time.Duration) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cutoff := time.Now().Add(-d).UnixNano()
+	var keys []string
+	for k, item := range p.items {
+		if item.Updated < cutoff {
+			keys = append(keys, k)
+		}
+	}
+
+	for _, k := range keys {
+		p.delete(k)
+	}
+	if len(keys) > 0 {
+		p.events.record(EventFlush, "")
+	}
+
+	return len(keys)
 }
 
+// ErrCacheFull is returned by writes that don't fit in Capacity or
+// MemoryLimit when the FullPolicy doesn't allow evicting live items
+var ErrCacheFull = errors.New("cache is full")
+
+// ErrItemTooLarge is returned by writes of items bigger than Option.MaxItemSize,
+// or than MemoryLimit
+var ErrItemTooLarge = errors.New("item is too large")
+
 type keyAndValue struct {
 	key   string
 	value interface{}
@@ -214,23 +488,87 @@ func (p *cache) OnEvicted(f func(string, interface{})) {
 	p.mu.Unlock()
 }
 
-// Size
+// Sets an (optional) function that is called with the key, the old value and
+// the new value when an unexpired item is overwritten by Set or Replace.
+// Set to nil to disable.
+func (p *cache) OnReplaced(f func(string, interface{}, interface{})) {
+	p.mu.Lock()
+	p.onReplaced = f
+	p.mu.Unlock()
+}
+
+// Sets an (optional) function that is called with the key and value every
+// time an item is stored, after OnReplaced if the item overwrote another.
+// Set to nil to disable.
+func (p *cache) OnSet(f func(string, interface{})) {
+	p.mu.Lock()
+	p.onSet = f
+	p.mu.Unlock()
+}
+
+// Size returns the number of items in the cache, including expired items that
+// haven't been cleaned up yet. It doesn't take the lock.
 func (p *cache) Size() int {
-	return len(p.items)
+	return int(atomic.LoadInt64(&p.count))
+}
+
+// Headroom returns how many more items and bytes fit before Capacity and
+// MemoryLimit are reached, or Unlimited (-1) for a limit that isn't set.
+// Expired items not cleaned up yet count as used. It doesn't take the lock,
+// so the answer may be stale by the time a Set runs.
+func (p *cache) Headroom() (itemsLeft int, bytesLeft int64) {
+	itemsLeft, bytesLeft = int(Unlimited), Unlimited
+
+	if p.option.Capacity > 0 {
+		itemsLeft = p.option.Capacity - p.Size()
+		if itemsLeft < 0 {
+			itemsLeft = 0
+		}
+	}
+
+	if p.option.MemoryLimit > 0 {
+		bytesLeft = p.option.MemoryLimit - p.Alloc()
+		if bytesLeft < 0 {
+			bytesLeft = 0
+		}
+	}
+
+	return itemsLeft, bytesLeft
 }
 
 // Interval Janitor
 type janitor struct {
 	Interval time.Duration
 	stop     chan bool
+
+	// Health, written by Run and read atomically by Stats
+	running     int32
+	passes      int64
+	lastStart   int64
+	lastEnd     int64
+	lastRemoved int64
 }
 
 func (p *janitor) Run(c *cache) {
+	atomic.StoreInt32(&p.running, 1)
+	defer atomic.StoreInt32(&p.running, 0)
+
 	ticker := time.NewTicker(p.Interval)
 	for {
 		select {
 		case <-ticker.C:
-			c.DeleteExpired()
+			atomic.StoreInt64(&p.lastStart, time.Now().UnixNano())
+			removed := c.deleteExpiredPass()
+			c.runRefreshes()
+			c.shrink()
+			c.purgeTombstones()
+			c.purgeLeases()
+			if c.option.IntegrityCheck {
+				c.repairIntegrity()
+			}
+			atomic.StoreInt64(&p.lastRemoved, int64(removed))
+			atomic.StoreInt64(&p.lastEnd, time.Now().UnixNano())
+			atomic.AddInt64(&p.passes, 1)
 		case <-p.stop:
 			ticker.Stop()
 			return
@@ -246,37 +584,101 @@ func runJanitor(p *cache, ci time.Duration) {
 	j := &janitor{
 		Interval: ci,
 		stop:     make(chan bool),
+		running:  1,
 	}
 	p.janitor = j
 	go j.Run(p)
 }
 
 // CRUD:
-func (p *cache) delete(k string) (interface{}, bool) {
-	var (
-		v     *Item
-		found bool
-	)
 
-	if v, found = p.items[k]; found {
-		delete(p.items, k)
+// write stores v under the lock once precondition (if any) passes, then runs
+// the OnReplaced and OnSet hooks after the lock is released so they may call
+// back into the cache.
+func (p *cache) write(k string, v interface{}, d time.Duration, precondition func() error, o *writeOptions) (interface{}, bool, error) {
+	var ctx context.Context
+	if o != nil {
+		ctx = o.ctx
+	}
+	if err := p.throttle(ctx); err != nil {
+		return nil, false, err
+	}
+	if err := p.lockContext(ctx); err != nil {
+		return nil, false, err
+	}
+
+	if precondition != nil {
+		if err := precondition(); err != nil {
+			p.mu.Unlock()
+			return nil, false, err
+		}
+	}
 
-		// Deduct usage
-		p.deductMemUsage(v.Mem)
+	previous, replaced, err := p.set(k, v, d, o)
+	hooks := p.writeHooks()
+	p.mu.Unlock()
 
-		// Delete in key manager
-		p.keyManager.Delete(k)
+	// Evictions stand even if the write failed afterwards
+	p.fireEvictions(hooks.evictions)
+	if err != nil {
+		return nil, false, err
 	}
 
-	if found && p.onEvicted != nil {
-		return v.Object, true
+	p.fireWriteHooks(hooks, k, v, previous, replaced)
+
+	return previous, replaced, nil
+}
+
+// writeHooks are the OnReplaced and OnSet hooks as captured under the lock,
+// with the evictions the write made
+type writeHooks struct {
+	onSet      func(string, any)
+	onReplaced func(string, any, any)
+	evictions  pendingEvictions
+}
+
+func (p *cache) writeHooks() writeHooks {
+	return writeHooks{
+		onSet:      p.onSet,
+		onReplaced: p.onReplaced,
+		evictions:  p.takeEvictions(),
 	}
+}
 
-	if found {
-		return v.Object, false
+// fireWriteHooks runs the hooks for a write of v, once the lock is released
+func (p *cache) fireWriteHooks(hooks writeHooks, k string, v, previous interface{}, replaced bool) {
+	if replaced && hooks.onReplaced != nil {
+		p.safely(func() { hooks.onReplaced(k, previous, v) })
 	}
 
-	return nil, false
+	if hooks.onSet != nil {
+		p.safely(func() { hooks.onSet(k, v) })
+	}
+}
+
+func (p *cache) delete(k string) (interface{}, bool) {
+	v, found := p.items[k]
+	if !found {
+		return nil, false
+	}
+
+	delete(p.items, k)
+	atomic.AddInt64(&p.count, -1)
+	p.release(k)
+
+	// Deduct usage
+	p.deductMemUsage(k, v.Mem)
+
+	// Delete in key manager
+	p.keyManager.Delete(k)
+	p.unscheduleExpiration(k)
+	delete(p.expireHooks, k)
+
+	// Give the Item back to the pool once its value is taken out
+	obj := v.Object
+	p.itemPool.put(v)
+
+	return obj, p.onEvicted != nil
 }
 
 func (p *cache) getItem(k string) (*Item, bool) {
@@ -287,7 +689,7 @@ func (p *cache) getItem(k string) (*Item, bool) {
 
 	// "Inlining" of Expired
 	if item.Expiration > 0 {
-		if time.Now().UnixNano() > item.Expiration {
+		if p.now() > item.Expiration {
 			return nil, false
 		}
 	}
@@ -295,7 +697,61 @@ func (p *cache) getItem(k string) (*Item, bool) {
 	return item, true
 }
 
-func (p *cache) set(k string, v interface{}, d time.Duration) error {
+// set is the single write primitive behind Set, Add and Replace.
+// An existing item under k is taken over: its memory is released before the
+// limits are checked and its key is moved to the back of the key manager
+// with Touch instead of being added twice. It returns the previous value
+// when an unexpired item was replaced.
+func (p *cache) set(k string, v interface{}, d time.Duration, o *writeOptions) (interface{}, bool, error) {
+	e := p.expiration(time.Now(), p.ttl(k, d))
+
+	var freshFor time.Duration
+	if o != nil {
+		freshFor = o.fresh
+	}
+	fresh := p.freshness(time.Now(), p.softTTL(k, freshFor), e)
+
+	// Size of Item: Value and Key
+	var size int64
+	switch {
+	case o != nil && o.sized:
+		size = p.flatItemSize(k, o.size)
+	case o != nil && o.unsized:
+		size = p.unsizedItemSize(k)
+	default:
+		measured, ok := p.measureItem(k, v, p.option.MaxItemSize)
+		if !ok {
+			return nil, false, ErrItemTooLarge
+		}
+		size = measured
+	}
+
+	if p.option.MaxItemSize > 0 && size > p.option.MaxItemSize {
+		return nil, false, ErrItemTooLarge
+	}
+
+	return p.store(k, v, e, fresh, size)
+}
+
+// freshness resolves the freshness of a write made at from, expiring at e,
+// to a Unix nano time, 0 for none. Freshness only matters while the item is
+// valid.
+func (p *cache) freshness(from time.Time, d time.Duration, e int64) int64 {
+	if d <= 0 {
+		return 0
+	}
+
+	fresh := from.Add(d).UnixNano()
+	if e > 0 && fresh > e {
+		return 0
+	}
+
+	return fresh
+}
+
+// expiration resolves a TTL given to a write made at from to a Unix nano
+// expiration, 0 for none
+func (p *cache) expiration(from time.Time, d time.Duration) int64 {
 	var (
 		e int64
 	)
@@ -307,60 +763,313 @@ func (p *cache) set(k string, v interface{}, d time.Duration) error {
 
 	// If Not Zero
 	if d > 0 {
-		e = time.Now().Add(d).UnixNano()
+		e = from.Add(d).UnixNano()
 	}
 
-	// Size of Item: Value and Key
-	size := p.calculateItemSize(k, v)
+	// MaxItemAge bounds the lifetime of every write, whatever its TTL
+	if p.option.MaxItemAge > 0 {
+		maxAge := from.Add(p.option.MaxItemAge).UnixNano()
+		if e == 0 || e > maxAge {
+			e = maxAge
+		}
+	}
+
+	return e
+}
+
+// writeOptions carries the settings of a write beyond its value and TTL
+type writeOptions struct {
+	fresh   time.Duration // see SetWithFreshness
+	unsized bool          // see SetUnsized
+	sized   bool          // see SetWithSize
+	size    int64
+	ctx     context.Context // see SetWithTimeout
+}
+
+// detach takes the item of k out of the items and the accounting, leaving
+// the key manager and the expiration heap to be fixed by reattach or store
+func (p *cache) detach(k string, item *Item) {
+	delete(p.items, k)
+	atomic.AddInt64(&p.count, -1)
+	p.deductMemUsage(k, item.Mem)
+	p.release(k)
+}
+
+// reattach puts back an item taken out by detach untouched, its key keeping
+// its place in the key manager
+func (p *cache) reattach(k string, item *Item) {
+	p.items[k] = item
+	atomic.AddInt64(&p.count, 1)
+	p.addMemUsage(k, item.Mem)
+	p.scheduleExpiration(k, item.Expiration)
+}
+
+// store is set with the expiration and freshness already resolved to Unix
+// nano times and the size already measured
+func (p *cache) store(k string, v interface{}, e, fresh, size int64) (interface{}, bool, error) {
+	// Detach the current item so it neither counts against the limits nor
+	// gets picked as an eviction victim for its own replacement
+	old, exists := p.items[k]
+
+	// Quotas are checked against what the item adds to its prefix
+	freed := int64(0)
+	if exists {
+		freed = old.Mem
+	}
+	if err := p.checkQuota(k, size-freed); err != nil {
+		return nil, false, err
+	}
+
+	var detached map[string]*Item
+	if exists {
+		p.detach(k, old)
+		detached = map[string]*Item{k: old}
+	}
+
+	if err := p.evict(1, size, detached); err != nil {
+		// Put the current item back untouched
+		if exists {
+			p.reattach(k, old)
+		}
+
+		return nil, false, err
+	}
+
+	delete(p.tombstones, k)
+	delete(p.leases, k)
+	delete(p.expireHooks, k)
+
+	item := p.itemPool.get()
+	item.Object = v
+	item.Expiration = e
+	item.Fresh = fresh
+	item.Mem = size
+	item.Updated = time.Now().UnixNano()
+
+	p.items[k] = item
+	atomic.AddInt64(&p.count, 1)
+	p.scheduleExpiration(k, e)
+	p.countLabelSet()
+	if len(p.items) > p.peakItems {
+		p.peakItems = len(p.items)
+	}
+
+	// Add MEM
+	p.addMemUsage(k, size)
+
+	if !exists {
+		// Add to key manager
+		p.trackKey(k)
+		p.events.record(EventSet, k)
+		return nil, false, nil
+	}
+
+	// Bring the key to last of the queue
+	p.touchKey(k)
+
+	var previous interface{}
+	replaced := !old.Expired()
+	if replaced {
+		previous = old.Object
+		p.events.record(EventReplace, k)
+	} else {
+		p.events.record(EventSet, k)
+	}
+	p.itemPool.put(old)
+
+	return previous, replaced, nil
+}
+
+// deleteExpired removes the items expired at now, as many as the budget
+// allows, and returns the ones OnEvicted should be called for, in eviction
+// order: earliest expiration first, ties broken by Option.Tiebreak, and how
+// many items were removed
+func (p *cache) deleteExpired(now int64, b *budget) ([]keyAndValue, int) {
+	var (
+		evictedItems []keyAndValue
+		removed      int
+	)
+
+	// A frozen cache only notes what it would remove
+	if p.frozen != nil {
+		p.holdExpired(now)
+		return nil, 0
+	}
+
+	// The heap yields the expired items without scanning the live ones. Once
+	// the budget is spent the rest is left to the next pass.
+	for p.expirations.Len() > 0 && now > p.expirations.entries[0].expiration && !b.spent() {
+		k := p.expirations.entries[0].key
+		if _, found := p.items[k]; found {
+			p.events.record(EventExpire, k)
+			p.recordEviction(EvictionExpired, k)
+			p.traceEviction(EvictionExpired, k, 0, false)
+			p.runExpireHooks(k)
+			removed++
+		}
+		ov, evicted := p.delete(k)
+		if evicted {
+			evictedItems = append(evictedItems, keyAndValue{k, ov})
+		}
+
+		// delete unschedules k; drop an entry left behind for a missing key
+		p.unscheduleExpiration(k)
+	}
+
+	return evictedItems, removed
+}
+
+// tiebreak reports whether a goes before b when eviction can't tell them
+// apart otherwise, using Option.Tiebreak or else key order
+func (p *cache) tiebreak(a, b string) bool {
+	if p.option.Tiebreak != nil {
+		return p.option.Tiebreak(a, b)
+	}
+
+	return a < b
+}
+
+// full reports whether n new items of size bytes in total would go over
+// Capacity or MemoryLimit
+func (p *cache) full(n int, size int64) bool {
+	if p.option.Capacity > 0 && p.Size()+n > p.option.Capacity {
+		return true
+	}
+
+	return p.option.MemoryLimit > 0 && p.memUsage+size > p.option.MemoryLimit
+}
+
+// fits checks that n new items of size bytes in total would fit in an empty
+// cache
+func (p *cache) fits(n int, size int64) error {
+	if p.option.Capacity > 0 && n > p.option.Capacity {
+		return ErrCacheFull
+	}
+
+	if p.option.MemoryLimit > 0 && size > p.option.MemoryLimit {
+		if n == 1 {
+			return ErrItemTooLarge
+		}
+
+		return ErrCacheFull
+	}
+
+	return nil
+}
+
+// evict makes room for n new items of size bytes in total according to the
+// FullPolicy. With EvictOldest it removes keys in the key manager's order
+// until both Capacity and MemoryLimit are satisfied. Keys the key manager
+// still holds but the cache no longer does are dropped from the key
+// manager and skipped. The detached keys being written keep their place:
+// once one of them comes up, the rest of the order is read from the key
+// manager's list, or, if it can't list its keys, they are moved to the back.
+func (p *cache) evict(n int, size int64, detached map[string]*Item) error {
+	policy := p.option.FullPolicy
+	// Without a key manager there is no order to evict live items in
+	if policy == EvictOldest && p.option.KeyManagerType == keymanager.None {
+		policy = RejectNew
+	}
+
+	// What can't fit in an empty cache evicts nothing
+	if err := p.fits(n, size); err != nil {
+		return err
+	}
+
+	if p.frozen != nil && policy != RejectNew {
+		return p.frozenEvict(policy, n, size)
+	}
+
+	switch policy {
+	case RejectNew:
+		if p.full(n, size) {
+			return ErrCacheFull
+		}
+
+		return nil
+
+	case EvictExpiredOnly:
+		if p.full(n, size) {
+			// OnEvicted is called for them once the lock is released
+			expired, _ := p.deleteExpired(time.Now().UnixNano(), p.newBudget())
+			p.expired = append(p.expired, expired...)
+		}
+
+		if p.full(n, size) {
+			return ErrCacheFull
+		}
+
+		return nil
+	}
+
+	victims := p.victims(detached)
+	defer victims.restore()
 
 	// Check capacity: if seted
-	if p.option.Capacity > 0 && (p.Size() >= p.option.Capacity) {
-		key, err := p.keyManager.Peek()
+	for p.option.Capacity > 0 && p.Size()+n > p.option.Capacity {
+		key, _, err := victims.next(false)
 		if err != nil {
 			return err
 		}
 
+		p.events.record(EventEvict, key)
+		p.recordEviction(EvictionCapacity, key)
+		p.traceEviction(EvictionCapacity, key, int64(len(p.items)+n-p.option.Capacity), false)
 		p.delete(key)
 	}
 
 	// Check memory limit:
 	if p.option.MemoryLimit > 0 && ((p.memUsage + size) >= p.option.MemoryLimit) {
+		if p.option.TrackLatency {
+			defer p.track(&p.latency.eviction, time.Now())
+		}
+
+		b := p.newBudget()
 		requireSpace := (p.memUsage + size) - p.option.MemoryLimit
 		for requireSpace > 0 {
-			key, err := p.keyManager.Peek()
-			if err != nil {
-				return err
-			}
-
-			if key == "" {
-				return errors.New("invalid key")
+			// Fail the write rather than stall it, what was evicted so far
+			// stays evicted for the next attempt
+			if b.spent() {
+				return ErrCacheFull
 			}
 
-			item, found := p.getItem(key)
-
-			if !found {
-				continue
+			key, fair, err := victims.next(true)
+			if err != nil {
+				return err
 			}
 
+			item := p.items[key]
+			p.traceEviction(EvictionMemory, key, requireSpace, fair)
 			requireSpace = requireSpace - item.Mem
+			p.events.record(EventEvict, key)
+			p.recordEviction(EvictionMemory, key)
 			p.delete(key)
 		}
-
 	}
 
-	p.items[k] = &Item{
-		Object:     v,
-		Expiration: e,
-		Mem:        size,
+	return nil
+}
+
+// shrink rebuilds the items map when it has emptied out far below its peak,
+// letting the GC reclaim the buckets of the old map
+func (p *cache) shrink() {
+	if p.option.ShrinkRatio <= 0 {
+		return
 	}
 
-	// Add MEM
-	p.addMemUsage(size)
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	// Add to key manager
-	p.keyManager.Add(k)
+	if float64(len(p.items)) >= float64(p.peakItems)*p.option.ShrinkRatio {
+		return
+	}
 
-	return nil
+	m := make(map[string]*Item, len(p.items))
+	for k, v := range p.items {
+		m[k] = v
+	}
+	p.items = m
+	p.peakItems = len(m)
 }
 
 func (p *cache) get(k string) (interface{}, bool) {
@@ -371,7 +1080,7 @@ func (p *cache) get(k string) (interface{}, bool) {
 
 	// "Inlining" of Expired
 	if item.Expiration > 0 {
-		if time.Now().UnixNano() > item.Expiration {
+		if p.now() > item.Expiration {
 			return nil, false
 		}
 	}
@@ -379,29 +1088,89 @@ func (p *cache) get(k string) (interface{}, bool) {
 	return item.Object, true
 }
 
+// safely runs a user callback. With an Option.PanicHandler set, a panic in
+// the callback is recovered and handed to it instead of unwinding the caller,
+// which may be the janitor goroutine.
+func (p *cache) safely(fn func()) {
+	if p.option.PanicHandler == nil {
+		fn()
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			p.option.PanicHandler(r)
+		}
+	}()
+
+	fn()
+}
+
 // MEMORY:
 func (p *cache) calculateItemSize(k string, v any) int64 {
+	size, _ := p.measureItem(k, v, 0)
+	return size
+}
+
+// measureItem sizes an item like calculateItemSize but gives up and returns
+// false as soon as the size is known to go over limit (no limit when <= 0)
+func (p *cache) measureItem(k string, v any, limit int64) (int64, bool) {
+	// Without a memory limit sizes are never looked at, skip the reflection
+	if p.option.MemoryLimit == Unlimited {
+		return 0, true
+	}
+
 	memKey := DeepSize(k)
-	memVals := DeepSize(v)
-	memPointer := PtrSize
+	memPointer := int64(PtrSize)
+	if limit > 0 {
+		limit = limit - memKey - memPointer
+		if limit <= 0 {
+			return 0, false
+		}
+	}
+
+	memVals, ok := deepSizeLimit(v, limit)
 
 	// fmt.Printf("Key: %d, Val: %d, Pointer: %d\n", memKey, memVals, memPointer)
 
-	return memKey + memVals + int64(memPointer)
+	return memKey + memVals + memPointer, ok
+}
+
+// unsizedItemSize is what SetUnsized charges: Option.UnsizedItemSize in
+// place of the value
+func (p *cache) unsizedItemSize(k string) int64 {
+	value := p.option.UnsizedItemSize
+	if value <= 0 {
+		value = defaultUnsizedItemSize
+	}
+
+	return p.flatItemSize(k, value)
+}
+
+// flatItemSize charges the key and pointer as usual and a given size for
+// the value
+func (p *cache) flatItemSize(k string, value int64) int64 {
+	if p.option.MemoryLimit == Unlimited {
+		return 0
+	}
+
+	return DeepSize(k) + value + int64(PtrSize)
 }
 
-func (p *cache) addMemUsage(mem int64) {
-	p.memUsage = p.memUsage + mem
+func (p *cache) addMemUsage(k string, mem int64) {
+	atomic.AddInt64(&p.memUsage, mem)
+	p.addQuotaUsage(k, mem)
 }
 
-func (p *cache) deductMemUsage(mem int64) {
+func (p *cache) deductMemUsage(k string, mem int64) {
+	p.addQuotaUsage(k, -mem)
 
 	left := p.memUsage - mem
 
 	if left < 0 {
-		p.memUsage = 0
+		atomic.StoreInt64(&p.memUsage, 0)
 		return
 	}
 
-	p.memUsage = left
+	atomic.StoreInt64(&p.memUsage, left)
 }
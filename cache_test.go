@@ -1,9 +1,12 @@
 package cache
 
 import (
+	"context"
 	"fmt"
+	"runtime"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -333,3 +336,2337 @@ func benchmarkCacheGetManyConcurrent(b *testing.B, exp time.Duration) {
 	b.StartTimer()
 	wg.Wait()
 }
+
+func TestItemPoolStats(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("a", "foo", NoExpiration)
+	c.Delete("a")
+	c.Set("b", "bar", NoExpiration)
+
+	stats := c.Stats()
+	assert.Equal(t, 1, stats.Items)
+	assert.Equal(t, int64(1), stats.ItemPoolReleases)
+	assert.Equal(t, stats.ItemPoolAllocs+stats.ItemPoolReuses, int64(2))
+
+	v, found := c.Get("b")
+	assert.True(t, found)
+	assert.Equal(t, "bar", v)
+}
+
+func TestExpectedItems(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit:   222222,
+		ExpectedItems: 100,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	for i := 0; i < 150; i++ {
+		assert.Nil(t, c.Set(fmt.Sprintf("%d", i), i, NoExpiration))
+	}
+
+	assert.Equal(t, 150, c.Size())
+	assert.Equal(t, 150, c.keyManager.Size())
+}
+
+func TestShrinkRatio(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+		ShrinkRatio: 0.5,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		c.Set(fmt.Sprintf("%d", i), i, NoExpiration)
+	}
+	assert.Equal(t, 10, c.peakItems)
+
+	for i := 0; i < 8; i++ {
+		c.Delete(fmt.Sprintf("%d", i))
+	}
+
+	c.shrink()
+	assert.Equal(t, 2, c.peakItems)
+	assert.Equal(t, 2, c.Size())
+
+	v, found := c.Get("9")
+	assert.True(t, found)
+	assert.Equal(t, 9, v)
+}
+
+func TestSetExistingKeyAccounting(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("a", "foo", NoExpiration)
+	c.Set("b", "foo", NoExpiration)
+	alloc := c.Alloc()
+
+	c.Set("a", "bar", NoExpiration)
+	assert.Equal(t, alloc, c.Alloc())
+	assert.Equal(t, 2, c.keyManager.Size())
+
+	// "a" was touched, "b" is now the oldest key
+	key, err := c.keyManager.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, "b", key)
+
+	assert.Nil(t, c.Replace("b", "baz", NoExpiration))
+	assert.Equal(t, alloc, c.Alloc())
+	assert.Equal(t, 2, c.keyManager.Size())
+
+	key, err = c.keyManager.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, "a", key)
+}
+
+func TestReplaceOldestUnderMemoryPressure(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 128,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	val := [4]int64{}
+	assert.Nil(t, c.Set("1", val, NoExpiration))
+	assert.Nil(t, c.Set("2", val, NoExpiration))
+
+	// Replacing the oldest key reuses its own space instead of evicting "2"
+	assert.Nil(t, c.Replace("1", val, NoExpiration))
+	assert.Equal(t, 2, c.Size())
+
+	_, found := c.Get("2")
+	assert.True(t, found)
+}
+
+func TestOnReplacedAndOnSet(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	var (
+		sets     []string
+		replaced []string
+	)
+	c.OnSet(func(k string, v interface{}) {
+		sets = append(sets, fmt.Sprintf("%s=%v", k, v))
+	})
+	c.OnReplaced(func(k string, old, new interface{}) {
+		replaced = append(replaced, fmt.Sprintf("%s:%v->%v", k, old, new))
+	})
+
+	c.Set("a", 1, NoExpiration)
+	c.Set("a", 2, NoExpiration)
+	c.Replace("a", 3, NoExpiration)
+	c.Add("a", 4, NoExpiration)
+
+	assert.Equal(t, []string{"a=1", "a=2", "a=3"}, sets)
+	assert.Equal(t, []string{"a:1->2", "a:2->3"}, replaced)
+}
+
+func TestSwap(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	previous, existed, err := c.Swap("a", 1, NoExpiration)
+	assert.Nil(t, err)
+	assert.False(t, existed)
+	assert.Nil(t, previous)
+
+	previous, existed, err = c.Swap("a", 2, NoExpiration)
+	assert.Nil(t, err)
+	assert.True(t, existed)
+	assert.Equal(t, 1, previous)
+
+	c.Set("b", 1, time.Millisecond)
+	<-time.After(2 * time.Millisecond)
+	previous, existed, err = c.Swap("b", 2, NoExpiration)
+	assert.Nil(t, err)
+	assert.False(t, existed)
+	assert.Nil(t, previous)
+
+	v, _ := c.Get("a")
+	assert.Equal(t, 2, v)
+}
+
+func TestGetAndDelete(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	var evicted []string
+	c.OnEvicted(func(k string, v interface{}) {
+		evicted = append(evicted, k)
+	})
+
+	c.Set("token", "abc", NoExpiration)
+
+	v, found := c.GetAndDelete("token")
+	assert.True(t, found)
+	assert.Equal(t, "abc", v)
+	assert.Equal(t, 0, c.Size())
+	assert.Equal(t, int64(0), c.Alloc())
+
+	v, found = c.GetAndDelete("token")
+	assert.False(t, found)
+	assert.Nil(t, v)
+	assert.Equal(t, []string{"token"}, evicted)
+}
+
+func TestExpire(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("a", 1, NoExpiration)
+
+	assert.True(t, c.Expire("a"))
+	assert.False(t, c.Expire("a"))
+	assert.False(t, c.Expire("b"))
+
+	_, found := c.Get("a")
+	assert.False(t, found)
+	assert.Equal(t, 1, c.Size())
+
+	c.DeleteExpired()
+	assert.Equal(t, 0, c.Size())
+}
+
+func TestNewWithInitData(t *testing.T) {
+	t.Run("SUCCESS", func(t *testing.T) {
+		c, err := New(&Option{
+			MemoryLimit: 222222,
+		}, map[string]*Item{
+			"b":       {Object: "bar"},
+			"a":       {Object: "foo", Expiration: time.Now().Add(time.Hour).UnixNano()},
+			"expired": {Object: "old", Expiration: time.Now().Add(-time.Hour).UnixNano()},
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, 2, c.Size())
+		assert.Equal(t, 2, c.keyManager.Size())
+		assert.Equal(t, c.calculateItemSize("a", "foo")+c.calculateItemSize("b", "bar"), c.Alloc())
+
+		key, _ := c.keyManager.Peek()
+		assert.Equal(t, "a", key)
+
+		_, found := c.Get("expired")
+		assert.False(t, found)
+	})
+
+	t.Run("FAIL_over capacity", func(t *testing.T) {
+		c, err := New(&Option{
+			MemoryLimit: 222222,
+			Capacity:    1,
+		}, map[string]*Item{
+			"a": {Object: "foo"},
+			"b": {Object: "bar"},
+		})
+
+		assert.NotNil(t, err)
+		assert.Nil(t, c)
+	})
+
+	t.Run("FAIL_over memory limit", func(t *testing.T) {
+		c, err := New(&Option{
+			MemoryLimit: 16,
+		}, map[string]*Item{
+			"a": {Object: "foo"},
+		})
+
+		assert.NotNil(t, err)
+		assert.Nil(t, c)
+	})
+
+	t.Run("SUCCESS_exactly at memory limit", func(t *testing.T) {
+		option := &Option{MemoryLimit: 222222}
+		size := newCache(option, nil).calculateItemSize("a", "foo")
+
+		option.MemoryLimit = size
+		c, err := New(option, map[string]*Item{
+			"a": {Object: "foo"},
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, size, c.Alloc())
+	})
+}
+
+func TestNewFromMap(t *testing.T) {
+	m := make(map[string]int, 2000)
+	for i := 0; i < 2000; i++ {
+		m[fmt.Sprintf("%d", i)] = i
+	}
+
+	c, err := NewFromMap(m, &Option{
+		MemoryLimit:       1 << 20,
+		DefaultExpiration: time.Hour,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2000, c.Size())
+	assert.Equal(t, 2000, c.keyManager.Size())
+
+	var alloc int64
+	for k, v := range m {
+		alloc += c.calculateItemSize(k, v)
+	}
+	assert.Equal(t, alloc, c.Alloc())
+
+	v, expiration, found := c.GetWithExpiration("42")
+	assert.True(t, found)
+	assert.Equal(t, 42, v)
+	assert.False(t, expiration.IsZero())
+}
+
+func TestFullPolicy(t *testing.T) {
+	t.Run("RejectNew", func(t *testing.T) {
+		c, err := New(&Option{
+			MemoryLimit: 222222,
+			Capacity:    2,
+			FullPolicy:  RejectNew,
+		}, nil)
+
+		assert.Nil(t, err)
+		assert.Nil(t, c.Set("1", 1, NoExpiration))
+		assert.Nil(t, c.Set("2", 2, NoExpiration))
+		assert.Equal(t, ErrCacheFull, c.Set("3", 3, NoExpiration))
+
+		// Overwriting an existing key still works
+		assert.Nil(t, c.Set("1", 10, NoExpiration))
+		assert.Equal(t, 2, c.Size())
+	})
+
+	t.Run("RejectNew memory limit", func(t *testing.T) {
+		c, err := New(&Option{
+			MemoryLimit: 128,
+			FullPolicy:  RejectNew,
+		}, nil)
+
+		assert.Nil(t, err)
+		val := [4]int64{}
+		assert.Nil(t, c.Set("1", val, NoExpiration))
+		assert.Nil(t, c.Set("2", val, NoExpiration))
+		assert.Equal(t, ErrCacheFull, c.Set("3", val, NoExpiration))
+
+		_, found := c.Get("1")
+		assert.True(t, found)
+	})
+
+	t.Run("EvictExpiredOnly", func(t *testing.T) {
+		c, err := New(&Option{
+			MemoryLimit: 222222,
+			Capacity:    2,
+			FullPolicy:  EvictExpiredOnly,
+		}, nil)
+
+		assert.Nil(t, err)
+		var evicted []string
+		c.OnEvicted(func(k string, _ interface{}) {
+			evicted = append(evicted, k)
+		})
+		assert.Nil(t, c.Set("1", 1, time.Millisecond))
+		assert.Nil(t, c.Set("2", 2, NoExpiration))
+		<-time.After(2 * time.Millisecond)
+
+		// Expired items removed for room go through OnEvicted too
+		assert.Nil(t, c.Set("3", 3, NoExpiration))
+		assert.Equal(t, []string{"1"}, evicted)
+		assert.Equal(t, ErrCacheFull, c.Set("4", 4, NoExpiration))
+
+		_, found := c.Get("2")
+		assert.True(t, found)
+	})
+}
+
+func TestMaxItemAge(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+		MaxItemAge:  10 * time.Millisecond,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("forever", 1, NoExpiration)
+	c.Set("long", 2, time.Hour)
+	c.Set("short", 3, time.Millisecond)
+
+	_, expiration, found := c.GetWithExpiration("forever")
+	assert.True(t, found)
+	assert.True(t, time.Until(expiration) <= 10*time.Millisecond)
+
+	<-time.After(15 * time.Millisecond)
+	for _, k := range []string{"forever", "long", "short"} {
+		_, found := c.Get(k)
+		assert.False(t, found)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+		Quota: Quota{
+			"tenant:a:": 150,
+		},
+	}, nil)
+
+	assert.Nil(t, err)
+
+	val := [4]int64{}
+	size := c.calculateItemSize("tenant:a:1", val)
+
+	assert.Nil(t, c.Set("tenant:a:1", val, NoExpiration))
+	assert.Nil(t, c.Set("tenant:a:2", val, NoExpiration))
+	assert.Equal(t, ErrQuotaExceeded, c.Set("tenant:a:3", val, NoExpiration))
+
+	// Overwrites only count the difference, other prefixes are not limited
+	assert.Nil(t, c.Set("tenant:a:1", val, NoExpiration))
+	assert.Nil(t, c.Set("tenant:b:1", val, NoExpiration))
+	assert.Nil(t, c.Set("tenant:b:2", val, NoExpiration))
+	assert.Nil(t, c.Set("tenant:b:3", val, NoExpiration))
+
+	assert.Equal(t, map[string]int64{"tenant:a:": 2 * size}, c.Stats().QuotaUsage)
+
+	c.Delete("tenant:a:2")
+	assert.Nil(t, c.Set("tenant:a:3", val, NoExpiration))
+	assert.Equal(t, 2*size, c.Stats().QuotaUsage["tenant:a:"])
+}
+
+func TestFairEviction(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 355,
+		Quota: Quota{
+			"a:": 1000,
+			"b:": 1000,
+		},
+		FairEviction: true,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	val := [4]int64{}
+	for _, k := range []string{"b:1", "b:2", "a:1", "a:2", "a:3", "a:4", "b:3"} {
+		assert.Nil(t, c.Set(k, val, NoExpiration))
+	}
+
+	// "a:" holds the largest share, so its oldest key goes instead of "b:1"
+	_, found := c.Get("a:1")
+	assert.False(t, found)
+	_, found = c.Get("b:1")
+	assert.True(t, found)
+	assert.Equal(t, 6, c.Size())
+}
+
+func TestSizeAndAllocLockFree(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	wg := new(sync.WaitGroup)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			c.Set(fmt.Sprintf("%d", i), i, NoExpiration)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			c.Size()
+			c.Alloc()
+		}
+	}()
+	wg.Wait()
+
+	assert.Equal(t, 100, c.Size())
+
+	c.Flush()
+	assert.Equal(t, 0, c.Size())
+	assert.Equal(t, int64(0), c.Alloc())
+	assert.Equal(t, 0, c.keyManager.Size())
+}
+
+func TestCheckIntegrity(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("a", 1, NoExpiration)
+	c.Set("b", 2, NoExpiration)
+	c.Set("c", 3, NoExpiration)
+	assert.True(t, c.CheckIntegrity().OK())
+
+	// Introduce drift behind the cache's back
+	c.memUsage += 10
+	c.keyManager.Delete("a")
+	c.keyManager.Add("ghost")
+
+	r := c.CheckIntegrity()
+	assert.False(t, r.OK())
+	assert.Equal(t, r.ItemsMem+10, r.MemUsage)
+	assert.Equal(t, []string{"a"}, r.MissingKeys)
+	assert.Equal(t, []string{"ghost"}, r.StaleKeys)
+
+	c.repairIntegrity()
+	assert.True(t, c.CheckIntegrity().OK())
+	assert.Equal(t, 3, c.keyManager.Size())
+
+	t.Run("Duplicate keys", func(t *testing.T) {
+		// The queue holds each key once, a custom key manager may not
+		keys := &sliceKeyManager{}
+		c.WithKeyManager(keys)
+		c.Flush()
+		c.Set("a", 1, NoExpiration)
+		c.Set("b", 2, NoExpiration)
+		keys.Add("b")
+
+		r := c.CheckIntegrity()
+		assert.False(t, r.OK())
+		assert.Equal(t, []string{"b"}, r.DuplicateKeys)
+
+		c.repairIntegrity()
+		assert.True(t, c.CheckIntegrity().OK())
+		assert.Equal(t, 2, keys.Size())
+	})
+}
+
+// sliceKeyManager is a FIFO key manager that doesn't check for duplicates
+type sliceKeyManager struct {
+	keys []string
+}
+
+func (p *sliceKeyManager) Add(key string) bool {
+	p.keys = append(p.keys, key)
+	return true
+}
+
+func (p *sliceKeyManager) Size() int { return len(p.keys) }
+
+func (p *sliceKeyManager) Delete(key string) {
+	for i, k := range p.keys {
+		if k == key {
+			p.keys = append(p.keys[:i], p.keys[i+1:]...)
+			return
+		}
+	}
+}
+
+func (p *sliceKeyManager) Peek() (string, error) {
+	if len(p.keys) == 0 {
+		return "", fmt.Errorf("no keys")
+	}
+
+	return p.keys[0], nil
+}
+
+func (p *sliceKeyManager) GetValues() []string {
+	return append([]string(nil), p.keys...)
+}
+
+func TestPanicHandler(t *testing.T) {
+	var recovered []interface{}
+	c, err := New(&Option{
+		MemoryLimit:     222222,
+		CleanupInterval: time.Millisecond,
+		PanicHandler: func(r interface{}) {
+			recovered = append(recovered, r)
+		},
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.OnSet(func(k string, v interface{}) {
+		panic("on set")
+	})
+	c.OnEvicted(func(k string, v interface{}) {
+		panic("on evicted")
+	})
+
+	assert.Nil(t, c.Set("a", 1, NoExpiration))
+	c.Delete("a")
+
+	// The cache keeps working after the panics
+	assert.Nil(t, c.Set("b", 2, NoExpiration))
+	v, found := c.Get("b")
+	assert.True(t, found)
+	assert.Equal(t, 2, v)
+
+	assert.Equal(t, []interface{}{"on set", "on evicted", "on set"}, recovered)
+}
+
+func TestEvictionOrder(t *testing.T) {
+	initData := func() map[string]*Item {
+		expiredAt := time.Now().Add(5 * time.Millisecond).UnixNano()
+		return map[string]*Item{
+			"c": {Object: 3, Expiration: expiredAt},
+			"a": {Object: 1, Expiration: expiredAt},
+			"b": {Object: 2, Expiration: expiredAt},
+			"0": {Object: 0, Expiration: expiredAt - 1},
+		}
+	}
+
+	collect := func(c *Cache) *[]string {
+		var keys []string
+		c.OnEvicted(func(k string, v interface{}) {
+			keys = append(keys, k)
+		})
+		return &keys
+	}
+
+	t.Run("Expiration then key order", func(t *testing.T) {
+		c, err := New(&Option{MemoryLimit: 222222}, initData())
+		assert.Nil(t, err)
+
+		keys := collect(c)
+		<-time.After(6 * time.Millisecond)
+		c.DeleteExpired()
+		assert.Equal(t, []string{"0", "a", "b", "c"}, *keys)
+	})
+
+	t.Run("Custom tiebreak", func(t *testing.T) {
+		c, err := New(&Option{
+			MemoryLimit:     222222,
+			EventBufferSize: 10,
+			Tiebreak: func(a, b string) bool {
+				return a > b
+			},
+		}, initData())
+		assert.Nil(t, err)
+
+		keys := collect(c)
+		var batch []string
+		c.OnEvictedBatch(func(kvs []KV, reason EvictionReason) {
+			for _, kv := range kvs {
+				batch = append(batch, kv.Key)
+			}
+		})
+		<-time.After(6 * time.Millisecond)
+		c.DeleteExpired()
+		assert.Equal(t, []string{"0", "c", "b", "a"}, *keys)
+		assert.Equal(t, []string{"0", "c", "b", "a"}, batch)
+
+		var events []string
+		for _, e := range c.RecentEvents(0) {
+			events = append(events, e.Key)
+		}
+		assert.Equal(t, []string{"0", "c", "b", "a"}, events)
+	})
+
+	t.Run("Capacity evicts in write order", func(t *testing.T) {
+		c, err := New(&Option{MemoryLimit: 222222, Capacity: 3}, nil)
+		assert.Nil(t, err)
+
+		for _, k := range []string{"x", "y", "z"} {
+			c.Set(k, k, NoExpiration)
+		}
+		c.Set("x", "x", NoExpiration)
+		c.Set("w", "w", NoExpiration)
+
+		_, found := c.Get("y")
+		assert.False(t, found)
+		for _, k := range []string{"x", "z", "w"} {
+			_, found := c.Get(k)
+			assert.True(t, found)
+		}
+	})
+}
+
+func TestTombstones(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit:  222222,
+		TombstoneTTL: 10 * time.Millisecond,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("a", 1, NoExpiration)
+	c.Set("b", 2, NoExpiration)
+	c.Set("c", 3, time.Millisecond)
+
+	c.Delete("a")
+	c.GetAndDelete("b")
+	c.Delete("missing")
+	<-time.After(2 * time.Millisecond)
+	c.DeleteExpired()
+
+	tombstones := c.Tombstones()
+	assert.Len(t, tombstones, 2)
+	assert.Contains(t, tombstones, "a")
+	assert.Contains(t, tombstones, "b")
+
+	// Writing the key again clears its tombstone
+	c.Set("b", 2, NoExpiration)
+	assert.Len(t, c.Tombstones(), 1)
+
+	<-time.After(11 * time.Millisecond)
+	assert.Len(t, c.Tombstones(), 0)
+	c.purgeTombstones()
+	assert.Len(t, c.tombstones, 0)
+}
+
+func TestSyncWith(t *testing.T) {
+	a, err := New(&Option{MemoryLimit: 222222, TombstoneTTL: time.Minute}, nil)
+	assert.Nil(t, err)
+	b, err := New(&Option{MemoryLimit: 222222}, nil)
+	assert.Nil(t, err)
+
+	a.Set("shared", "old", NoExpiration)
+	b.Set("only-b", 1, time.Hour)
+	b.Set("shared", "new", NoExpiration)
+	b.Set("deleted-in-a", 2, NoExpiration)
+	a.Set("deleted-in-a", 2, NoExpiration)
+	a.Delete("deleted-in-a")
+
+	copied, err := a.SyncWith(b)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, copied)
+
+	v, expiration, found := a.GetWithExpiration("only-b")
+	assert.True(t, found)
+	assert.Equal(t, 1, v)
+	_, peerExpiration, _ := b.GetWithExpiration("only-b")
+	assert.Equal(t, peerExpiration, expiration)
+
+	v, _ = a.Get("shared")
+	assert.Equal(t, "new", v)
+	_, found = a.Get("deleted-in-a")
+	assert.False(t, found)
+
+	// Both sides now hold the same versions
+	copied, err = a.SyncWith(b)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, copied)
+
+	copied, err = b.SyncWith(a)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, copied)
+}
+
+func TestLease(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+		LeaseTTL:    10 * time.Millisecond,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	t.Run("Only the lease holder fills", func(t *testing.T) {
+		_, token, found := c.LeaseGet("a")
+		assert.False(t, found)
+		assert.NotZero(t, token)
+
+		_, other, found := c.LeaseGet("a")
+		assert.False(t, found)
+		assert.Zero(t, other)
+
+		assert.Equal(t, ErrInvalidLease, c.LeaseSet("a", 1, token+1, NoExpiration))
+		assert.Nil(t, c.LeaseSet("a", 1, token, NoExpiration))
+		assert.Equal(t, ErrInvalidLease, c.LeaseSet("a", 2, token, NoExpiration))
+
+		v, token, found := c.LeaseGet("a")
+		assert.True(t, found)
+		assert.Zero(t, token)
+		assert.Equal(t, 1, v)
+	})
+
+	t.Run("Delete invalidates the lease", func(t *testing.T) {
+		_, token, _ := c.LeaseGet("b")
+		c.Delete("b")
+		assert.Equal(t, ErrInvalidLease, c.LeaseSet("b", 1, token, NoExpiration))
+	})
+
+	t.Run("Stale value while the lease is held", func(t *testing.T) {
+		c.Set("c", "old", time.Millisecond)
+		<-time.After(2 * time.Millisecond)
+
+		_, token, _ := c.LeaseGet("c")
+		assert.NotZero(t, token)
+
+		v, other, found := c.LeaseGet("c")
+		assert.False(t, found)
+		assert.Zero(t, other)
+		assert.Equal(t, "old", v)
+	})
+
+	t.Run("Lease times out", func(t *testing.T) {
+		_, token, _ := c.LeaseGet("d")
+		<-time.After(11 * time.Millisecond)
+		assert.Equal(t, ErrInvalidLease, c.LeaseSet("d", 1, token, NoExpiration))
+
+		_, again, _ := c.LeaseGet("d")
+		assert.NotZero(t, again)
+	})
+}
+
+func TestFreshness(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	assert.Nil(t, c.SetWithFreshness("a", 1, 5*time.Millisecond, 15*time.Millisecond))
+	c.Set("b", 2, 15*time.Millisecond)
+
+	v, stale, found := c.GetWithFreshness("a")
+	assert.True(t, found)
+	assert.False(t, stale)
+	assert.Equal(t, 1, v)
+
+	<-time.After(7 * time.Millisecond)
+	v, stale, found = c.GetWithFreshness("a")
+	assert.True(t, found)
+	assert.True(t, stale)
+	assert.Equal(t, 1, v)
+
+	_, stale, found = c.GetWithFreshness("b")
+	assert.True(t, found)
+	assert.False(t, stale)
+
+	<-time.After(10 * time.Millisecond)
+	_, _, found = c.GetWithFreshness("a")
+	assert.False(t, found)
+
+	// A plain write resets the freshness
+	assert.Nil(t, c.SetWithFreshness("a", 1, time.Nanosecond, NoExpiration))
+	c.Set("a", 1, NoExpiration)
+	<-time.After(time.Millisecond)
+	_, stale, _ = c.GetWithFreshness("a")
+	assert.False(t, stale)
+}
+
+func TestExpirationHelpers(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit:       222222,
+		DefaultExpiration: time.Hour,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("never", 1, Never())
+	c.Set("default", 2, Default())
+	c.Set("in", 3, In(time.Minute))
+	c.Set("at", 4, At(time.Now().Add(-time.Second)))
+
+	_, expiration, found := c.GetWithExpiration("never")
+	assert.True(t, found)
+	assert.True(t, expiration.IsZero())
+
+	_, expiration, _ = c.GetWithExpiration("default")
+	assert.True(t, time.Until(expiration) > 59*time.Minute)
+
+	_, expiration, _ = c.GetWithExpiration("in")
+	assert.True(t, time.Until(expiration) <= time.Minute)
+
+	// A time in the past expires the item instead of falling back to the default
+	_, found = c.Get("at")
+	assert.False(t, found)
+
+	assert.Equal(t, ZeroExpiration, DefaultExpiration)
+}
+
+func TestUnlimitedMemory(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: Unlimited,
+		Capacity:    3,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, c.Set(fmt.Sprintf("%d", i), make([]byte, 1<<20), NoExpiration))
+	}
+
+	assert.Equal(t, 3, c.Size())
+	assert.Equal(t, int64(0), c.Alloc())
+
+	// Patch deltas aren't charged either
+	assert.Nil(t, c.Patch("4", func(v interface{}) (interface{}, int64, error) { return v, 100, nil }))
+	assert.Equal(t, int64(0), c.Alloc())
+
+	_, err = New(&Option{MemoryLimit: -2}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestSetUnsized(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit:     222222,
+		UnsizedItemSize: 100,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	assert.Nil(t, c.SetUnsized("a", make([]byte, 1<<16), NoExpiration))
+	assert.Equal(t, DeepSize("a")+100+int64(PtrSize), c.Alloc())
+
+	v, found := c.Get("a")
+	assert.True(t, found)
+	assert.Len(t, v, 1<<16)
+
+	// A measured write replaces the flat charge
+	assert.Nil(t, c.Set("a", "x", NoExpiration))
+	assert.Equal(t, c.calculateItemSize("a", "x"), c.Alloc())
+}
+
+func TestMaxItemSize(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 1 << 20,
+		MaxItemSize: 1024,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	assert.Nil(t, c.Set("small", make([]byte, 512), NoExpiration))
+	assert.Equal(t, ErrItemTooLarge, c.Set("big", make([]byte, 2048), NoExpiration))
+	assert.Equal(t, ErrItemTooLarge, c.Set("many", make([]string, 5000), NoExpiration))
+	assert.Equal(t, 1, c.Size())
+}
+
+func TestSetWithSize(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 4096,
+		MaxItemSize: 2048,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	payload := make([]byte, 1000)
+	assert.Nil(t, c.SetWithSize("a", payload, int64(len(payload)), NoExpiration))
+	assert.Equal(t, DeepSize("a")+1000+int64(PtrSize), c.Alloc())
+
+	assert.Equal(t, ErrItemTooLarge, c.SetWithSize("b", payload, 3000, NoExpiration))
+	assert.NotNil(t, c.SetWithSize("c", payload, -1, NoExpiration))
+
+	// The memory limit evicts based on the given sizes
+	for _, k := range []string{"d", "e", "f", "g"} {
+		assert.Nil(t, c.SetWithSize(k, payload, int64(len(payload)), NoExpiration))
+	}
+	assert.Equal(t, 3, c.Size())
+	_, found := c.Get("a")
+	assert.False(t, found)
+}
+
+func TestPatch(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	assert.Nil(t, c.SetWithSize("list", []int64{1, 2}, 16, time.Hour))
+	alloc := c.Alloc()
+	_, expiration, _ := c.GetWithExpiration("list")
+
+	err = c.Patch("list", func(current interface{}) (interface{}, int64, error) {
+		return append(current.([]int64), 3), 8, nil
+	})
+	assert.Nil(t, err)
+
+	v, patchedExpiration, found := c.GetWithExpiration("list")
+	assert.True(t, found)
+	assert.Equal(t, []int64{1, 2, 3}, v)
+	assert.Equal(t, expiration, patchedExpiration)
+	assert.Equal(t, alloc+8, c.Alloc())
+
+	failure := fmt.Errorf("boom")
+	err = c.Patch("list", func(current interface{}) (interface{}, int64, error) {
+		return nil, 0, failure
+	})
+	assert.Equal(t, failure, err)
+	assert.Equal(t, alloc+8, c.Alloc())
+
+	err = c.Patch("missing", func(current interface{}) (interface{}, int64, error) {
+		return current, 0, nil
+	})
+	assert.NotNil(t, err)
+}
+
+func TestExpiringBefore(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	_, found := c.NextExpiration()
+	assert.False(t, found)
+
+	c.Set("c", 1, 3*time.Hour)
+	c.Set("a", 1, time.Hour)
+	c.Set("b", 1, 2*time.Hour)
+	c.Set("forever", 1, NoExpiration)
+
+	next, found := c.NextExpiration()
+	assert.True(t, found)
+	_, expiration, _ := c.GetWithExpiration("a")
+	assert.Equal(t, expiration.UnixNano(), next.UnixNano())
+
+	now := time.Now()
+	assert.Equal(t, []string{"a", "b"}, c.ExpiringBefore(now.Add(150*time.Minute)))
+	assert.Equal(t, []string{"a", "b", "c"}, c.ExpiringBefore(now.Add(24*time.Hour)))
+
+	// Rewrites and deletes reschedule
+	c.Set("a", 1, NoExpiration)
+	c.Delete("b")
+	assert.Equal(t, []string{"c"}, c.ExpiringBefore(now.Add(24*time.Hour)))
+
+	assert.True(t, c.Expire("forever"))
+	assert.Equal(t, []string{"forever", "c"}, c.ExpiringBefore(now.Add(24*time.Hour)))
+
+	c.DeleteExpired()
+	assert.Equal(t, []string{"c"}, c.ExpiringBefore(now.Add(24*time.Hour)))
+
+	c.Flush()
+	_, found = c.NextExpiration()
+	assert.False(t, found)
+}
+
+func TestRecentEvents(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit:     222222,
+		Capacity:        2,
+		EventBufferSize: 4,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	assert.Empty(t, c.RecentEvents(10))
+
+	c.Set("a", 1, NoExpiration)
+	c.Set("a", 2, NoExpiration)
+	c.Set("b", 1, NoExpiration)
+	c.Set("c", 1, NoExpiration)
+	c.Delete("b")
+
+	events := c.RecentEvents(0)
+	types := make([]string, len(events))
+	for i, event := range events {
+		types[i] = event.Type.String() + " " + event.Key
+	}
+	// The buffer only holds the last 4, the writes of a are gone
+	assert.Equal(t, []string{"set b", "evict a", "set c", "delete b"}, types)
+
+	assert.Equal(t, events[2:], c.RecentEvents(2))
+
+	c.Flush()
+	events = c.RecentEvents(1)
+	assert.Equal(t, EventFlush, events[0].Type)
+
+	// Without a buffer nothing is kept
+	c, _ = New(&Option{MemoryLimit: 222222}, nil)
+	c.Set("a", 1, NoExpiration)
+	assert.Empty(t, c.RecentEvents(10))
+}
+
+func TestSetWithTimeout(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	assert.Nil(t, c.SetWithTimeout(context.Background(), "a", 1, NoExpiration))
+
+	// Hold the lock as a long eviction pass would
+	c.mu.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.Equal(t, ErrLockTimeout, c.SetWithTimeout(ctx, "b", 1, NoExpiration))
+	assert.Less(t, time.Since(start), time.Second)
+	c.mu.Unlock()
+
+	_, found := c.Get("b")
+	assert.False(t, found)
+
+	// Once the lock is released it goes through
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.mu.Lock()
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		c.mu.Unlock()
+	}()
+	assert.Nil(t, c.SetWithTimeout(ctx, "b", 2, NoExpiration))
+	v, found := c.Get("b")
+	assert.True(t, found)
+	assert.Equal(t, 2, v)
+}
+
+func TestEvictionBudget(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit:    222222,
+		EvictionBudget: time.Nanosecond,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	for i := 0; i < 200; i++ {
+		c.Set(strconv.Itoa(i), i, time.Millisecond)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// Each pass stops once the budget is spent and the next one resumes
+	c.DeleteExpired()
+	assert.Equal(t, 200-budgetCheckInterval+1, c.Size())
+
+	for i := 0; i < 4 && c.Size() > 0; i++ {
+		c.DeleteExpired()
+	}
+	assert.Equal(t, 0, c.Size())
+
+	// A write that can't make room in time fails instead of stalling
+	c, err = New(&Option{
+		MemoryLimit:    20000,
+		EvictionBudget: time.Nanosecond,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, c.SetWithSize(strconv.Itoa(i), i, 100, NoExpiration))
+	}
+	assert.Equal(t, ErrCacheFull, c.SetWithSize("big", 0, 19000, NoExpiration))
+	assert.Less(t, c.Size(), 100)
+}
+
+func TestNoKeyManager(t *testing.T) {
+	c, err := New(&Option{
+		KeyManagerType: keymanager.None,
+		MemoryLimit:    222222,
+		Capacity:       2,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	assert.Nil(t, c.Set("a", 1, NoExpiration))
+	assert.Nil(t, c.Set("b", 1, NoExpiration))
+	assert.Equal(t, ErrCacheFull, c.Set("c", 1, NoExpiration))
+
+	// Replacing doesn't need room
+	assert.Nil(t, c.Set("a", 2, NoExpiration))
+
+	c.Delete("b")
+	assert.Nil(t, c.Set("c", 1, NoExpiration))
+
+	// The memory limit rejects too, rather than failing on an empty key
+	c, err = New(&Option{
+		KeyManagerType: keymanager.None,
+		MemoryLimit:    1000,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	assert.Nil(t, c.SetWithSize("a", 1, 500, NoExpiration))
+	assert.Equal(t, ErrCacheFull, c.SetWithSize("b", 1, 500, NoExpiration))
+	v, found := c.Get("a")
+	assert.True(t, found)
+	assert.Equal(t, 1, v)
+
+	// Expired items can still make room
+	c, err = New(&Option{
+		KeyManagerType: keymanager.None,
+		MemoryLimit:    222222,
+		Capacity:       1,
+		FullPolicy:     EvictExpiredOnly,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	assert.Nil(t, c.Set("a", 1, time.Millisecond))
+	time.Sleep(2 * time.Millisecond)
+	assert.Nil(t, c.Set("b", 1, NoExpiration))
+}
+
+func TestQuotaHits(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+		Quota:       Quota{"a:": 1000, "b:": 1000},
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("a:1", 1, NoExpiration)
+	c.Set("other", 1, NoExpiration)
+
+	c.Get("a:1")
+	c.Get("a:1")
+	c.GetWithExpiration("a:1")
+	c.Get("a:2")
+	c.Get("b:1")
+	c.Get("other")
+
+	stats := c.Stats()
+	assert.Equal(t, HitStats{Hits: 3, Misses: 1}, stats.QuotaHits["a:"])
+	assert.Equal(t, 0.75, stats.QuotaHits["a:"].Ratio())
+	assert.Equal(t, HitStats{Misses: 1}, stats.QuotaHits["b:"])
+	assert.Len(t, stats.QuotaHits, 2)
+}
+
+func TestFlushOlderThan(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	evicted := 0
+	c.OnEvicted(func(string, interface{}) { evicted++ })
+
+	c.Set("a", 1, NoExpiration)
+	c.Set("b", 1, NoExpiration)
+	time.Sleep(20 * time.Millisecond)
+	c.Set("c", 1, NoExpiration)
+	// Rewriting counts as an update
+	c.Set("a", 2, NoExpiration)
+
+	assert.Equal(t, 1, c.FlushOlderThan(10*time.Millisecond))
+	assert.Equal(t, 2, c.Size())
+	_, found := c.Get("b")
+	assert.False(t, found)
+	assert.Equal(t, 0, evicted)
+
+	assert.Equal(t, 0, c.FlushOlderThan(time.Hour))
+	assert.Equal(t, 2, c.FlushOlderThan(-time.Hour))
+	assert.Equal(t, 0, c.Size())
+	assert.Equal(t, int64(0), c.Alloc())
+}
+
+func TestHeadroom(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 10000,
+		Capacity:    3,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	items, bytes := c.Headroom()
+	assert.Equal(t, 3, items)
+	assert.Equal(t, int64(10000), bytes)
+
+	assert.Nil(t, c.SetWithSize("a", 1, 1000, NoExpiration))
+	items, bytes = c.Headroom()
+	assert.Equal(t, 2, items)
+	assert.Equal(t, 10000-c.Alloc(), bytes)
+
+	c, err = New(&Option{
+		MemoryLimit: Unlimited,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	items, bytes = c.Headroom()
+	assert.Equal(t, -1, items)
+	assert.Equal(t, Unlimited, bytes)
+}
+
+func TestCanStore(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+		Capacity:    2,
+		MaxItemSize: 1000,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("a", 1, NoExpiration)
+	c.Set("b", 1, NoExpiration)
+	alloc := c.Alloc()
+
+	size, wouldEvict, err := c.CanStore("c", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, DeepSize("c")+DeepSize(1)+int64(PtrSize), size)
+	assert.Equal(t, []string{"a"}, wouldEvict)
+
+	// Nothing changed
+	assert.Equal(t, 2, c.Size())
+	assert.Equal(t, alloc, c.Alloc())
+
+	// Replacing needs no room
+	_, wouldEvict, err = c.CanStore("a", 2)
+	assert.Nil(t, err)
+	assert.Empty(t, wouldEvict)
+
+	_, _, err = c.CanStore("c", make([]byte, 2000))
+	assert.Equal(t, ErrItemTooLarge, err)
+
+	// The prediction matches what Set does
+	c.Set("c", 1, NoExpiration)
+	_, found := c.Get("a")
+	assert.False(t, found)
+
+	t.Run("Memory limit", func(t *testing.T) {
+		c, err := New(&Option{
+			MemoryLimit: 1000,
+		}, nil)
+
+		assert.Nil(t, err)
+
+		c.SetWithSize("a", 1, 300, NoExpiration)
+		c.SetWithSize("b", 1, 300, NoExpiration)
+		c.SetWithSize("c", 1, 300, NoExpiration)
+
+		_, wouldEvict, err := c.CanStore("d", make([]byte, 500))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"a", "b"}, wouldEvict)
+	})
+
+	t.Run("Reject new", func(t *testing.T) {
+		c, err := New(&Option{
+			MemoryLimit: 222222,
+			Capacity:    1,
+			FullPolicy:  RejectNew,
+		}, nil)
+
+		assert.Nil(t, err)
+
+		c.Set("a", 1, NoExpiration)
+		_, _, err = c.CanStore("b", 1)
+		assert.Equal(t, ErrCacheFull, err)
+	})
+
+	t.Run("Evict expired only", func(t *testing.T) {
+		c, err := New(&Option{
+			MemoryLimit: 222222,
+			Capacity:    2,
+			FullPolicy:  EvictExpiredOnly,
+		}, nil)
+
+		assert.Nil(t, err)
+
+		c.Set("a", 1, NoExpiration)
+		c.Set("b", 1, time.Millisecond)
+		time.Sleep(2 * time.Millisecond)
+
+		_, wouldEvict, err := c.CanStore("c", 1)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"b"}, wouldEvict)
+	})
+}
+
+func TestScheduleOnExpire(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	fired := make(chan string, 4)
+	c.Set("a", 1, 5*time.Millisecond)
+	c.Set("b", 1, 5*time.Millisecond)
+	c.Set("forever", 1, NoExpiration)
+
+	assert.True(t, c.ScheduleOnExpire("a", func() { fired <- "a" }))
+	assert.True(t, c.ScheduleOnExpire("b", func() { fired <- "b" }))
+	assert.False(t, c.ScheduleOnExpire("forever", func() { fired <- "forever" }))
+	assert.False(t, c.ScheduleOnExpire("missing", func() { fired <- "missing" }))
+
+	// Deleting drops the function
+	c.Delete("b")
+
+	time.Sleep(10 * time.Millisecond)
+	c.DeleteExpired()
+
+	select {
+	case k := <-fired:
+		assert.Equal(t, "a", k)
+	case <-time.After(time.Second):
+		t.Fatal("function scheduled on a was not run")
+	}
+
+	select {
+	case k := <-fired:
+		t.Errorf("unexpected run for %s", k)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestHas(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("a", 1, NoExpiration)
+	c.Set("b", 1, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+
+	assert.True(t, c.Has("a"))
+	assert.True(t, c.HasUnexpired("a"))
+
+	// Expired but not cleaned up yet
+	assert.True(t, c.Has("b"))
+	assert.False(t, c.HasUnexpired("b"))
+
+	assert.False(t, c.Has("missing"))
+	assert.False(t, c.HasUnexpired("missing"))
+}
+
+func TestView(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	users := View[string](c, "user:")
+	counts := View[int](c, "count:")
+
+	assert.Nil(t, users.Set("1", "ada", NoExpiration))
+	assert.Nil(t, counts.Set("1", 42, NoExpiration))
+
+	name, found := users.Get("1")
+	assert.True(t, found)
+	assert.Equal(t, "ada", name)
+
+	count, found := counts.Get("1")
+	assert.True(t, found)
+	assert.Equal(t, 42, count)
+
+	// Both share the parent
+	assert.Equal(t, 2, c.Size())
+	v, found := c.Get("user:1")
+	assert.True(t, found)
+	assert.Equal(t, "ada", v)
+
+	// A value of another type isn't returned
+	c.Set("user:2", 2, NoExpiration)
+	_, found = users.Get("2")
+	assert.False(t, found)
+
+	users.Delete("1")
+	_, found = users.Get("1")
+	assert.False(t, found)
+}
+
+func TestTimeResolution(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit:    222222,
+		TimeResolution: 100 * time.Millisecond,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("a", 1, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	// The clock hasn't ticked yet
+	_, found := c.Get("a")
+	assert.True(t, found)
+
+	time.Sleep(150 * time.Millisecond)
+	_, found = c.Get("a")
+	assert.False(t, found)
+}
+
+func BenchmarkCacheGetCoarseTime(b *testing.B) {
+	for _, resolution := range []time.Duration{0, time.Millisecond} {
+		b.Run(resolution.String(), func(b *testing.B) {
+			c, _ := New(&Option{
+				MemoryLimit:    222222,
+				TimeResolution: resolution,
+			}, nil)
+			c.Set("foo", "bar", time.Hour)
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					c.Get("foo")
+				}
+			})
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	sum := func(old, new interface{}) interface{} {
+		return old.(int) + new.(int)
+	}
+
+	assert.Nil(t, c.Merge("total", 1, sum, NoExpiration))
+	v, _ := c.Get("total")
+	assert.Equal(t, 1, v)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Merge("total", 2, sum, NoExpiration)
+		}()
+	}
+	wg.Wait()
+
+	v, _ = c.Get("total")
+	assert.Equal(t, 201, v)
+
+	// An expired item isn't merged with
+	c.Set("short", 5, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	assert.Nil(t, c.Merge("short", 1, sum, NoExpiration))
+	v, _ = c.Get("short")
+	assert.Equal(t, 1, v)
+}
+
+func TestMaxSetsPerSecond(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit:      222222,
+		MaxSetsPerSecond: 10,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, c.Set(strconv.Itoa(i), i, NoExpiration))
+	}
+	assert.Equal(t, ErrRateLimited, c.Set("over", 1, NoExpiration))
+	_, found := c.Get("over")
+	assert.False(t, found)
+
+	// Tokens come back over time
+	time.Sleep(150 * time.Millisecond)
+	assert.Nil(t, c.Set("later", 1, NoExpiration))
+
+	t.Run("Blocking", func(t *testing.T) {
+		c, err := New(&Option{
+			MemoryLimit:      222222,
+			MaxSetsPerSecond: 100,
+			BlockOnRateLimit: true,
+		}, nil)
+
+		assert.Nil(t, err)
+
+		start := time.Now()
+		for i := 0; i < 102; i++ {
+			assert.Nil(t, c.Set(strconv.Itoa(i), i, NoExpiration))
+		}
+		assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+
+		// A blocked write gives up with its context
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.Equal(t, ErrRateLimited, c.SetWithTimeout(ctx, "a", 1, NoExpiration))
+	})
+}
+
+func TestWithLabel(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	search := c.WithLabel("search-service")
+	feed := c.WithLabel("feed-service")
+
+	assert.Nil(t, search.Set("a", 1, NoExpiration))
+	search.Get("a")
+	search.Get("b")
+	feed.Get("a")
+	c.Get("a")
+
+	// Handles share the cache and, with the same label, the counters
+	v, found := feed.Get("a")
+	assert.True(t, found)
+	assert.Equal(t, 1, v)
+	c.WithLabel("search-service").Get("a")
+
+	stats := c.Stats()
+	assert.Equal(t, LabelStats{HitStats: HitStats{Hits: 2, Misses: 1}, Sets: 1}, stats.Labels["search-service"])
+	assert.Equal(t, LabelStats{HitStats: HitStats{Hits: 2}}, stats.Labels["feed-service"])
+	assert.Len(t, stats.Labels, 2)
+}
+
+func TestJanitorStats(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+	assert.Equal(t, JanitorStats{}, c.Stats().Janitor)
+
+	c, err = New(&Option{
+		MemoryLimit:     222222,
+		CleanupInterval: 50 * time.Millisecond,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("a", 1, time.Millisecond)
+	c.Set("b", 1, time.Millisecond)
+	assert.True(t, c.Stats().Janitor.Running)
+
+	time.Sleep(75 * time.Millisecond)
+	stats := c.Stats().Janitor
+	assert.True(t, stats.Running)
+	assert.Equal(t, int64(1), stats.Passes)
+	assert.Equal(t, 2, stats.LastRemoved)
+	assert.False(t, stats.LastEnd.Before(stats.LastStart))
+
+	stopJanitor(c.cache)
+	time.Sleep(5 * time.Millisecond)
+	assert.False(t, c.Stats().Janitor.Running)
+}
+
+func TestSlowOnEvicted(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit:    222222,
+		SlowOnEvicted:  5 * time.Millisecond,
+		AsyncOnEvicted: true,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	evicted := make(chan string, 3)
+	c.OnEvicted(func(k string, _ interface{}) {
+		time.Sleep(10 * time.Millisecond)
+		evicted <- k
+	})
+
+	c.Set("a", 1, NoExpiration)
+	c.Set("b", 1, NoExpiration)
+
+	// The first slow call runs inline and switches to async dispatch
+	c.Delete("a")
+	assert.Equal(t, "a", <-evicted)
+	stats := c.Stats()
+	assert.Equal(t, int64(1), stats.SlowOnEvicted)
+	assert.True(t, stats.OnEvictedAsync)
+
+	start := time.Now()
+	c.Delete("b")
+	assert.Less(t, time.Since(start), 10*time.Millisecond)
+	assert.Equal(t, "b", <-evicted)
+}
+
+func TestDecayKeyManager(t *testing.T) {
+	c, err := New(&Option{
+		KeyManagerType: keymanager.Decay,
+		DecayHalfLife:  time.Hour,
+		MemoryLimit:    222222,
+		Capacity:       2,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("a", 1, NoExpiration)
+	c.Set("b", 1, NoExpiration)
+	c.Get("a")
+
+	// Reads count, so b goes first although a was written first
+	c.Set("c", 1, NoExpiration)
+	_, found := c.Get("b")
+	assert.False(t, found)
+	_, found = c.Get("a")
+	assert.True(t, found)
+}
+
+func TestTTLRules(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+		TTLRules:    TTLRules{"session:": time.Hour},
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("session:1", 1, time.Millisecond)
+	c.Set("other", 1, time.Minute)
+	_, expiration, _ := c.GetWithExpiration("session:1")
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiration, time.Second)
+
+	// New rules apply to the writes that follow
+	assert.Equal(t, 0, c.SetTTLRules(TTLRules{"session:": NoExpiration, "session:admin:": time.Minute}, false))
+	c.Set("session:2", 1, time.Millisecond)
+	c.Set("session:admin:1", 1, NoExpiration)
+	_, expiration, _ = c.GetWithExpiration("session:2")
+	assert.True(t, expiration.IsZero())
+	_, expiration, _ = c.GetWithExpiration("session:admin:1")
+	assert.WithinDuration(t, time.Now().Add(time.Minute), expiration, time.Second)
+
+	// Retroactive rules rewrite the expiration of existing items
+	assert.Equal(t, 3, c.SetTTLRules(TTLRules{"session:": time.Nanosecond}, true))
+	next, _ := c.NextExpiration()
+	assert.True(t, next.Before(time.Now()))
+	c.DeleteExpired()
+	assert.Equal(t, 1, c.Size())
+	_, found := c.Get("other")
+	assert.True(t, found)
+}
+
+func TestKeyManagerHoldsUniqueKeys(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+		Capacity:    3,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("a", 1, NoExpiration)
+	c.Set("b", 1, NoExpiration)
+	c.Set("a", 2, NoExpiration)
+	assert.Nil(t, c.Replace("b", 2, NoExpiration))
+	c.Set("b", 3, NoExpiration)
+	assert.Nil(t, c.Replace("a", 3, NoExpiration))
+	c.Add("a", 4, NoExpiration)
+
+	assert.Equal(t, 2, c.keyManager.Size())
+	assert.Equal(t, []string{"b", "a"}, c.keyManager.(keyLister).GetValues())
+
+	// Eviction follows the last writes
+	c.Set("c", 1, NoExpiration)
+	c.Set("d", 1, NoExpiration)
+	assert.Equal(t, 3, c.keyManager.Size())
+	_, found := c.Get("b")
+	assert.False(t, found)
+	_, found = c.Get("a")
+	assert.True(t, found)
+}
+
+func TestScheduleRefresh(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, c.ScheduleRefresh("k", time.Second, func(string) (interface{}, error) { return 1, nil }))
+
+	c, err = New(&Option{
+		MemoryLimit:     222222,
+		CleanupInterval: 5 * time.Millisecond,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	var calls int64
+	assert.Nil(t, c.ScheduleRefresh("k", 20*time.Millisecond, func(k string) (interface{}, error) {
+		n := atomic.AddInt64(&calls, 1)
+		if n > 3 {
+			return nil, fmt.Errorf("backend down")
+		}
+		return n, nil
+	}))
+
+	time.Sleep(100 * time.Millisecond)
+	c.CancelRefresh("k")
+	n := atomic.LoadInt64(&calls)
+	assert.GreaterOrEqual(t, n, int64(3))
+	assert.LessOrEqual(t, n, int64(7))
+
+	// Failed refreshes keep the last value
+	v, found := c.Get("k")
+	assert.True(t, found)
+	assert.Equal(t, int64(3), v)
+
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, n, atomic.LoadInt64(&calls))
+}
+
+func TestOnEvictedBatch(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+		Capacity:    3,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	type call struct {
+		keys   []string
+		reason EvictionReason
+	}
+	var calls []call
+	c.OnEvictedBatch(func(items []KV, reason EvictionReason) {
+		keys := make([]string, len(items))
+		for i, item := range items {
+			keys[i] = item.Key
+		}
+		calls = append(calls, call{keys, reason})
+	})
+
+	c.Set("a", 1, time.Millisecond)
+	c.Set("b", 2, time.Millisecond)
+	c.Set("c", 3, NoExpiration)
+	time.Sleep(2 * time.Millisecond)
+
+	// One call for every expired item of the pass
+	c.DeleteExpired()
+	assert.Equal(t, []call{{[]string{"a", "b"}, EvictionExpired}}, calls)
+
+	// Items evicted for room are reported too
+	c.Set("d", 4, NoExpiration)
+	c.Set("e", 5, NoExpiration)
+	c.Set("f", 6, NoExpiration)
+	assert.Equal(t, call{[]string{"c"}, EvictionCapacity}, calls[1])
+
+	c.Delete("d")
+	assert.Equal(t, call{[]string{"d"}, EvictionDeleted}, calls[2])
+	assert.Len(t, calls, 3)
+	assert.Equal(t, "capacity", EvictionCapacity.String())
+}
+
+func TestKeyManagerCapacity(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit:        222222,
+		Capacity:           5,
+		KeyManagerCapacity: 2,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		c.Set(k, 1, NoExpiration)
+	}
+	assert.Equal(t, 5, c.Size())
+	assert.Equal(t, []string{"d", "e"}, c.keyManager.(keyLister).GetValues())
+	assert.Equal(t, []string{"a", "b", "c"}, c.untrackedKeys())
+	assert.True(t, c.CheckIntegrity().OK())
+
+	// An untracked key written again is tracked again
+	c.Set("b", 2, NoExpiration)
+	assert.Equal(t, []string{"e", "b"}, c.keyManager.(keyLister).GetValues())
+	assert.Equal(t, []string{"a", "c", "d"}, c.untrackedKeys())
+
+	// Untracked keys are evicted first, the sample covers all three so the
+	// oldest written goes
+	c.Set("f", 1, NoExpiration)
+	_, found := c.Get("a")
+	assert.False(t, found)
+	assert.Equal(t, 5, c.Size())
+	assert.Equal(t, []string{"c", "d", "e"}, c.untrackedKeys())
+	assert.True(t, c.CheckIntegrity().OK())
+
+	c.Delete("d")
+	assert.Equal(t, []string{"c", "e"}, c.untrackedKeys())
+
+	_, err = New(&Option{MemoryLimit: 222222, KeyManagerCapacity: -1}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestDeterministicSeed(t *testing.T) {
+	newCache := func(seed int64) *Cache {
+		c, err := New(&Option{
+			MemoryLimit:       222222,
+			DeterministicSeed: seed,
+		}, nil)
+		assert.Nil(t, err)
+
+		return c
+	}
+
+	a, b, other := newCache(42), newCache(42), newCache(7)
+	for i := 0; i < 3; i++ {
+		n := a.random().Int63()
+		assert.Equal(t, n, b.random().Int63())
+		assert.NotEqual(t, n, other.random().Int63())
+	}
+
+	// Untracked keys evicted past KeyManagerCapacity are drawn from the seed
+	evictions := func() []string {
+		c, err := New(&Option{
+			MemoryLimit:        222222,
+			Capacity:           50,
+			KeyManagerCapacity: 10,
+			DeterministicSeed:  42,
+		}, nil)
+		assert.Nil(t, err)
+
+		var evicted []string
+		c.OnEvictedBatch(func(kvs []KV, reason EvictionReason) {
+			for _, kv := range kvs {
+				evicted = append(evicted, kv.Key)
+			}
+		})
+		for i := 0; i < 100; i++ {
+			c.Set(strconv.Itoa(i), i, NoExpiration)
+		}
+
+		return evicted
+	}
+
+	first := evictions()
+	assert.Len(t, first, 50)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, first, evictions())
+	}
+}
+
+func TestTypedCache(t *testing.T) {
+	type point struct{ X, Y int }
+
+	c, err := NewTyped[point, string](&Option{MemoryLimit: 222222})
+	assert.Nil(t, err)
+
+	assert.Nil(t, c.Set(point{1, 2}, "a", NoExpiration))
+	assert.Nil(t, c.Set(point{2, 1}, "b", NoExpiration))
+	assert.NotNil(t, c.Add(point{1, 2}, "c", NoExpiration))
+
+	v, found := c.Get(point{1, 2})
+	assert.True(t, found)
+	assert.Equal(t, "a", v)
+	assert.Equal(t, 2, c.Size())
+
+	v, found = c.GetAndDelete(point{2, 1})
+	assert.True(t, found)
+	assert.Equal(t, "b", v)
+	_, found = c.Get(point{2, 1})
+	assert.False(t, found)
+
+	// Values of another type read as missing
+	ints := Typed[string, int](c.cache)
+	c.cache.Set("s", "not an int", NoExpiration)
+	_, found = ints.Get("s")
+	assert.False(t, found)
+	assert.Equal(t, "s", KeyString("s"))
+}
+
+func TestEvictionTrace(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit:       1000,
+		Capacity:          3,
+		EvictionTraceSize: 2,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.SetWithSize("a", 1, 100, NoExpiration)
+	c.SetWithSize("b", 1, 400, NoExpiration)
+	c.SetWithSize("c", 1, 100, NoExpiration)
+	assert.Len(t, c.EvictionTrace(0), 0)
+
+	// One item over Capacity, then bytes over MemoryLimit
+	c.SetWithSize("d", 1, 600, NoExpiration)
+	trace := c.EvictionTrace(0)
+	assert.Len(t, trace, 2)
+	overhead := trace[0].Size - 100
+
+	assert.Equal(t, "a", trace[0].Key)
+	assert.Equal(t, EvictionCapacity, trace[0].Reason)
+	assert.Equal(t, 3, trace[0].Items)
+	assert.Equal(t, int64(1), trace[0].Needed)
+
+	assert.Equal(t, "b", trace[1].Key)
+	assert.Equal(t, EvictionMemory, trace[1].Reason)
+	assert.Equal(t, 500+2*overhead, trace[1].MemUsage)
+	assert.Equal(t, 100+3*overhead, trace[1].Needed)
+	assert.Equal(t, 400+overhead, trace[1].Size)
+
+	// Only the last EvictionTraceSize decisions are kept
+	c.SetWithSize("e", 1, 300, NoExpiration)
+	trace = c.EvictionTrace(0)
+	assert.Len(t, trace, 2)
+	assert.Equal(t, "b", trace[0].Key)
+	assert.Equal(t, "c", trace[1].Key)
+	assert.Equal(t, trace[1:], c.EvictionTrace(1))
+}
+
+func TestGetMulti(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("a", 1, NoExpiration)
+	c.Set("b", 2, NoExpiration)
+	c.Set("c", 3, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	assert.Equal(t, map[string]interface{}{"a": 1, "b": 2}, c.GetMulti([]string{"a", "b", "c", "d"}))
+	assert.Empty(t, c.GetMulti(nil))
+
+	labeled := c.WithLabel("batch")
+	labeled.GetMulti([]string{"a", "d"})
+	assert.Equal(t, LabelStats{HitStats: HitStats{Hits: 1, Misses: 1}}, c.Stats().Labels["batch"])
+}
+
+func TestSoftTTLRules(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit:  222222,
+		MaxItemAge:   time.Hour,
+		SoftTTLRules: TTLRules{"feed:": time.Nanosecond, "feed:pinned:": time.Hour},
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("feed:1", 1, NoExpiration)
+	c.Set("feed:pinned:1", 1, NoExpiration)
+	c.SetWithFreshness("feed:2", 1, time.Hour, NoExpiration)
+	time.Sleep(time.Millisecond)
+
+	_, stale, found := c.GetWithFreshness("feed:1")
+	assert.True(t, found)
+	assert.True(t, stale)
+	_, stale, _ = c.GetWithFreshness("feed:pinned:1")
+	assert.False(t, stale)
+	_, stale, _ = c.GetWithFreshness("feed:2")
+	assert.False(t, stale)
+
+	// The hard bound still applies under a soft TTL
+	_, expiration, _ := c.GetWithExpiration("feed:1")
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiration, time.Second)
+
+	// Retroactive rules rewrite the freshness of existing items
+	assert.Equal(t, 1, c.SetSoftTTLRules(TTLRules{"feed:pinned:": time.Nanosecond}, true))
+	_, stale, _ = c.GetWithFreshness("feed:pinned:1")
+	assert.True(t, stale)
+}
+
+func TestSetMulti(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+		Capacity:    4,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	var replaced []string
+	c.OnReplaced(func(k string, _, _ interface{}) {
+		replaced = append(replaced, k)
+	})
+	var batches [][]KV
+	c.OnEvictedBatch(func(items []KV, reason EvictionReason) {
+		batches = append(batches, items)
+	})
+
+	c.Set("a", 1, NoExpiration)
+	c.Set("b", 1, NoExpiration)
+	c.Set("c", 1, NoExpiration)
+
+	// One pass evicts for the whole batch, sparing the keys it replaces
+	assert.Nil(t, c.SetMulti(map[string]interface{}{"c": 2, "d": 2, "e": 2}, NoExpiration))
+	assert.Equal(t, 4, c.Size())
+	assert.Equal(t, [][]KV{{{"a", 1}}}, batches)
+	assert.Equal(t, []string{"c"}, replaced)
+	assert.Equal(t, map[string]interface{}{"b": 1, "c": 2, "d": 2, "e": 2}, c.GetMulti([]string{"a", "b", "c", "d", "e"}))
+	assert.True(t, c.CheckIntegrity().OK())
+
+	// A batch that can't fit writes nothing
+	err = c.SetMulti(map[string]interface{}{"1": 1, "2": 2, "3": 3, "4": 4, "5": 5}, NoExpiration)
+	assert.Equal(t, ErrCacheFull, err)
+	assert.Equal(t, 4, c.Size())
+	assert.Nil(t, c.SetMulti(nil, NoExpiration))
+}
+
+func TestForEach(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("a", 1, NoExpiration)
+	c.Set("b", 2, NoExpiration)
+	c.Set("c", 3, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	// Writes made by fn don't change what is visited
+	seen := make(map[string]interface{})
+	c.ForEach(func(k string, v interface{}) bool {
+		seen[k] = v
+		c.Set(k+"!", v, NoExpiration)
+		c.Delete("a")
+		return true
+	})
+	assert.Equal(t, map[string]interface{}{"a": 1, "b": 2}, seen)
+
+	visited := 0
+	c.ForEach(func(string, interface{}) bool {
+		visited++
+		return false
+	})
+	assert.Equal(t, 1, visited)
+}
+
+func TestDeleteMulti(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	// Callbacks run after the lock is released, so they may use the cache
+	var evicted []string
+	c.OnEvicted(func(k string, _ interface{}) {
+		evicted = append(evicted, k)
+		c.Set("seen:"+k, 1, NoExpiration)
+	})
+	var batches []EvictionReason
+	c.OnEvictedBatch(func(_ []KV, reason EvictionReason) {
+		batches = append(batches, reason)
+	})
+
+	c.Set("a", 1, NoExpiration)
+	c.Set("b", 2, NoExpiration)
+	c.Set("c", 3, NoExpiration)
+
+	c.DeleteMulti([]string{"c", "a", "missing"})
+	assert.Equal(t, []string{"c", "a"}, evicted)
+	assert.Equal(t, []EvictionReason{EvictionDeleted}, batches)
+	assert.Equal(t, map[string]interface{}{"b": 2}, c.GetMulti([]string{"a", "b", "c"}))
+	assert.True(t, c.Has("seen:a"))
+}
+
+func TestDeleteReleasesLockBeforeOnEvicted(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.OnEvicted(func(string, interface{}) {
+		panic("boom")
+	})
+	c.Set("a", 1, NoExpiration)
+
+	assert.Panics(t, func() { c.Delete("a") })
+	assert.Nil(t, c.Set("b", 1, NoExpiration))
+	assert.False(t, c.Has("a"))
+}
+
+func TestPatchReleasesLockOnPanic(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("a", 1, NoExpiration)
+	assert.Panics(t, func() {
+		c.Patch("a", func(interface{}) (interface{}, int64, error) {
+			panic("boom")
+		})
+	})
+
+	assert.Nil(t, c.Set("b", 1, NoExpiration))
+	v, _ := c.Get("a")
+	assert.Equal(t, 1, v)
+}
+
+func TestMergeReleasesLockOnPanic(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("a", 1, NoExpiration)
+	assert.Panics(t, func() {
+		c.Merge("a", 2, func(old, new interface{}) interface{} {
+			panic("boom")
+		}, NoExpiration)
+	})
+
+	assert.Nil(t, c.Set("b", 1, NoExpiration))
+	v, _ := c.Get("a")
+	assert.Equal(t, 1, v)
+}
+
+func TestRead(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("a", []byte("ab"), NoExpiration)
+	c.Set("b", []byte("cd"), NoExpiration)
+
+	var dst []byte
+	copyOut := func(v interface{}) { dst = append(dst, v.([]byte)...) }
+	assert.True(t, c.Read("a", copyOut))
+	assert.False(t, c.Read("missing", copyOut))
+	assert.Equal(t, "ab", string(dst))
+
+	n := c.ReadMulti([]string{"b", "missing", "a"}, func(_ string, v interface{}) { copyOut(v) })
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "abcdab", string(dst))
+}
+
+func TestSyncWithFiresEvictions(t *testing.T) {
+	a, err := New(&Option{MemoryLimit: 222222, Capacity: 1}, nil)
+	assert.Nil(t, err)
+	b, err := New(&Option{MemoryLimit: 222222}, nil)
+	assert.Nil(t, err)
+
+	var evicted []string
+	a.OnEvictedBatch(func(items []KV, _ EvictionReason) {
+		for _, item := range items {
+			evicted = append(evicted, item.Key)
+		}
+	})
+
+	a.Set("local", 1, NoExpiration)
+	b.Set("remote", 2, NoExpiration)
+
+	copied, err := a.SyncWith(b)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, copied)
+	assert.Equal(t, []string{"local"}, evicted)
+}
+
+func TestSyncWithMeasuresLocally(t *testing.T) {
+	a, err := New(&Option{MemoryLimit: 222222, MaxItemSize: 64}, nil)
+	assert.Nil(t, err)
+	b, err := New(&Option{MemoryLimit: Unlimited}, nil)
+	assert.Nil(t, err)
+
+	b.Set("small", 1, NoExpiration)
+	copied, err := a.SyncWith(b)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, copied)
+	assert.Greater(t, a.Alloc(), int64(0))
+
+	// An unlimited peer charges nothing, the local limits still apply
+	b.Set("large", make([]byte, 1024), NoExpiration)
+	_, err = a.SyncWith(b)
+	assert.Equal(t, ErrItemTooLarge, err)
+	assert.False(t, a.Has("large"))
+}
+
+func TestInitDataIsCopied(t *testing.T) {
+	initData := map[string]*Item{
+		"a": {Object: 1},
+	}
+
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, initData)
+
+	assert.Nil(t, err)
+
+	// Recycling the cache's Item leaves the caller's untouched
+	c.Delete("a")
+	c.Set("b", 2, NoExpiration)
+	assert.Equal(t, Item{Object: 1}, *initData["a"])
+}
+
+func TestExpireWithTimeResolution(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit:    222222,
+		TimeResolution: time.Hour,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("a", 1, NoExpiration)
+	assert.True(t, c.Expire("a"))
+	_, found := c.Get("a")
+	assert.False(t, found)
+}
+
+func TestWithLabelCountsEveryCall(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 222222,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	l := c.WithLabel("l")
+	assert.Nil(t, l.Add("a", 1, NoExpiration))
+	assert.Nil(t, l.Replace("a", 2, NoExpiration))
+	_, _, err = l.Swap("a", 3, NoExpiration)
+	assert.Nil(t, err)
+	assert.Nil(t, l.SetWithSize("b", "x", 1, NoExpiration))
+	assert.Nil(t, l.SetUnsized("c", 1, NoExpiration))
+	assert.Nil(t, l.Merge("a", 1, func(old, new interface{}) interface{} { return old }, NoExpiration))
+	assert.Nil(t, l.Patch("a", func(v interface{}) (interface{}, int64, error) { return v, 0, nil }))
+	assert.NotNil(t, l.Add("a", 1, NoExpiration))
+
+	l.GetWithExpiration("a")
+	l.GetWithFreshness("b")
+	l.GetWithFreshness("missing")
+	c.Get("a")
+
+	assert.Equal(t, LabelStats{HitStats: HitStats{Hits: 2, Misses: 1}, Sets: 7}, c.Stats().Labels["l"])
+}
+
+func TestWithLabelOutlivesRoot(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit:    222222,
+		TimeResolution: time.Millisecond,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	l := c.WithLabel("l")
+	l.Set("a", 1, 20*time.Millisecond)
+
+	// Only the labeled handle is kept: the clock must keep running
+	c = nil
+	runtime.GC()
+	runtime.GC()
+	time.Sleep(100 * time.Millisecond)
+
+	_, found := l.Get("a")
+	assert.False(t, found)
+}
+
+func TestFailedOverwriteKeepsPlace(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit:    20000,
+		EvictionBudget: time.Nanosecond,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, c.SetWithSize(strconv.Itoa(i), i, 100, NoExpiration))
+	}
+
+	// The oldest key is skipped rather than evicted, and stays the oldest
+	// when the budget runs out
+	assert.Equal(t, ErrCacheFull, c.SetWithSize("0", 0, 19500, NoExpiration))
+	v, found := c.Get("0")
+	assert.True(t, found)
+	assert.Equal(t, 0, v)
+	assert.Equal(t, "0", c.keyManager.(keyLister).GetValues()[0])
+	assert.True(t, c.CheckIntegrity().OK())
+}
+
+func TestWriteBiggerThanMemoryLimit(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit: 1000,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	assert.Nil(t, c.SetWithSize("a", 1, 100, NoExpiration))
+	assert.Nil(t, c.SetWithSize("b", 2, 100, NoExpiration))
+
+	// Nothing is evicted for a value that can't fit anyway
+	assert.Equal(t, ErrItemTooLarge, c.SetWithSize("big", 3, 2000, NoExpiration))
+	assert.Equal(t, ErrItemTooLarge, c.SetWithSize("a", 3, 2000, NoExpiration))
+	assert.Equal(t, 2, c.Size())
+
+	_, _, err = c.CanStore("big", make([]byte, 2000))
+	assert.Equal(t, ErrItemTooLarge, err)
+}
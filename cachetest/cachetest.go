@@ -0,0 +1,32 @@
+// Package cachetest helps testing code built on the cache
+package cachetest
+
+import (
+	cache "github.com/manhcuongincusar1/pointer-cache"
+)
+
+// FreezeEviction stops c from evicting and expiring items, so the contents
+// of the cache only change with the writes and deletes of the test. It
+// returns the function thawing c again, which reports what c would have
+// removed meanwhile, in order:
+//
+//	thaw := cachetest.FreezeEviction(c)
+//	// ... exercise c
+//	evicted := thaw()
+//
+// See Cache.FreezeEviction for the details.
+func FreezeEviction(c *cache.Cache) (thaw func() []cache.FrozenEviction) {
+	c.FreezeEviction()
+
+	return c.ThawEviction
+}
+
+// Keys returns the keys of evicted, in order
+func Keys(evicted []cache.FrozenEviction) []string {
+	keys := make([]string, len(evicted))
+	for i, e := range evicted {
+		keys[i] = e.Key
+	}
+
+	return keys
+}
@@ -0,0 +1,46 @@
+package cachetest
+
+import (
+	"testing"
+	"time"
+
+	cache "github.com/manhcuongincusar1/pointer-cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFreezeEviction(t *testing.T) {
+	c, err := cache.New(&cache.Option{
+		MemoryLimit: 222222,
+		Capacity:    2,
+	}, nil)
+	assert.Nil(t, err)
+
+	c.Set("a", 1, time.Millisecond)
+	c.Set("b", 2, cache.NoExpiration)
+
+	thaw := FreezeEviction(c)
+	c.Set("c", 3, cache.NoExpiration)
+	c.Set("d", 4, cache.NoExpiration)
+	time.Sleep(2 * time.Millisecond)
+	c.DeleteExpired()
+
+	// Nothing is removed while frozen
+	assert.Equal(t, 4, c.Size())
+	_, found := c.Get("b")
+	assert.True(t, found)
+
+	// Deleting a held item drops it from the report
+	c.Delete("c")
+
+	evicted := thaw()
+	assert.Equal(t, []string{"a", "b"}, Keys(evicted))
+	assert.Equal(t, cache.EvictionCapacity, evicted[0].Reason)
+
+	// Eviction picks up where it left off
+	c.Set("e", 5, cache.NoExpiration)
+	assert.Equal(t, 2, c.Size())
+	_, found = c.Get("d")
+	assert.True(t, found)
+	_, found = c.Get("e")
+	assert.True(t, found)
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// clock is the coarse time source of Option.TimeResolution: a timestamp
+// refreshed by a ticker, so reads load it instead of calling time.Now
+type clock struct {
+	now  int64
+	stop chan bool
+}
+
+func (p *clock) Run(resolution time.Duration) {
+	ticker := time.NewTicker(resolution)
+	for {
+		select {
+		case <-ticker.C:
+			atomic.StoreInt64(&p.now, time.Now().UnixNano())
+		case <-p.stop:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+func runClock(p *cache, resolution time.Duration) {
+	c := &clock{
+		now:  time.Now().UnixNano(),
+		stop: make(chan bool),
+	}
+	p.clock = c
+	go c.Run(resolution)
+}
+
+func stopClock(p *cache) {
+	p.clock.stop <- true
+}
+
+// now is the time reads check expirations against, in Unix nanoseconds
+func (p *cache) now() int64 {
+	if p.clock != nil {
+		return atomic.LoadInt64(&p.clock.now)
+	}
+
+	return time.Now().UnixNano()
+}
@@ -0,0 +1,84 @@
+package compilecache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"sync"
+	"text/template"
+
+	cache "github.com/manhcuongincusar1/pointer-cache"
+)
+
+// MemoryLimit is the budget of the shared cache, read when it is first used
+var MemoryLimit int64 = 32 << 20
+
+var (
+	shared     *cache.Cache
+	sharedErr  error
+	sharedOnce sync.Once
+)
+
+// sharedCache returns the cache behind the helpers. Compiled objects are
+// pinned: it rejects new entries once full instead of evicting, so a hot
+// pattern is never recompiled because of a burst of one-off patterns.
+// The error of creating it, e.g. for an invalid MemoryLimit, is kept.
+func sharedCache() (*cache.Cache, error) {
+	sharedOnce.Do(func() {
+		shared, sharedErr = cache.New(&cache.Option{
+			MemoryLimit: MemoryLimit,
+			FullPolicy:  cache.RejectNew,
+		}, nil)
+	})
+
+	return shared, sharedErr
+}
+
+// CompiledRegexp returns the compiled form of pattern, compiling it once.
+// The returned Regexp is shared and safe for concurrent use.
+func CompiledRegexp(pattern string) (*regexp.Regexp, error) {
+	c, err := sharedCache()
+	if err != nil {
+		return nil, err
+	}
+
+	key := "regexp:" + pattern
+	if v, found := c.Get(key); found {
+		return v.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	// Storing only fails when the cache is full, the result is still good
+	c.Add(key, re, cache.NoExpiration)
+
+	return re, nil
+}
+
+// ParsedTemplate returns text parsed as a template called name, parsing it
+// once per name and text. The returned Template is shared: callers must not
+// Parse more into it, but may Clone it first.
+func ParsedTemplate(name, text string) (*template.Template, error) {
+	c, err := sharedCache()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(text))
+	key := "template:" + name + ":" + hex.EncodeToString(sum[:])
+	if v, found := c.Get(key); found {
+		return v.(*template.Template), nil
+	}
+
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Add(key, tmpl, cache.NoExpiration)
+
+	return tmpl, nil
+}
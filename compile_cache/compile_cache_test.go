@@ -0,0 +1,60 @@
+package compilecache
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompiledRegexp(t *testing.T) {
+	re, err := CompiledRegexp(`^a+b$`)
+	assert.Nil(t, err)
+	assert.True(t, re.MatchString("aab"))
+
+	again, err := CompiledRegexp(`^a+b$`)
+	assert.Nil(t, err)
+	assert.True(t, re == again)
+
+	_, err = CompiledRegexp(`(`)
+	assert.NotNil(t, err)
+}
+
+func TestParsedTemplate(t *testing.T) {
+	tmpl, err := ParsedTemplate("greet", "hello {{.}}")
+	assert.Nil(t, err)
+
+	again, err := ParsedTemplate("greet", "hello {{.}}")
+	assert.Nil(t, err)
+	assert.True(t, tmpl == again)
+
+	other, err := ParsedTemplate("greet", "bye {{.}}")
+	assert.Nil(t, err)
+	assert.False(t, tmpl == other)
+
+	var buf bytes.Buffer
+	assert.Nil(t, tmpl.Execute(&buf, "world"))
+	assert.Equal(t, "hello world", buf.String())
+
+	_, err = ParsedTemplate("broken", "{{")
+	assert.NotNil(t, err)
+}
+
+func TestSharedCacheError(t *testing.T) {
+	reset := func() {
+		shared, sharedErr, sharedOnce = nil, nil, sync.Once{}
+	}
+	defer func(limit int64) {
+		MemoryLimit = limit
+		reset()
+	}(MemoryLimit)
+
+	MemoryLimit = 0
+	reset()
+
+	_, err := CompiledRegexp(`a`)
+	assert.NotNil(t, err)
+	_, err = ParsedTemplate("greet", "hello {{.}}")
+	assert.NotNil(t, err)
+}
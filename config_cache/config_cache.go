@@ -0,0 +1,131 @@
+package configcache
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	cache "github.com/manhcuongincusar1/pointer-cache"
+)
+
+// Source loads the current configuration, already parsed, e.g. from a file,
+// the environment or a remote store
+type Source interface {
+	Load() (map[string]interface{}, error)
+}
+
+// SourceFunc adapts a function to Source
+type SourceFunc func() (map[string]interface{}, error)
+
+func (f SourceFunc) Load() (map[string]interface{}, error) {
+	return f()
+}
+
+// Adapter keeps the configuration loaded from a Source in a cache.
+// The whole configuration is stored as one entry, so a reload replaces it
+// atomically: readers see either the old or the new values, never a mix.
+type Adapter struct {
+	cache  *cache.Cache
+	key    string
+	source Source
+
+	mu          sync.Mutex
+	subscribers []func(changed []string)
+}
+
+// New creates an Adapter storing the configuration under key in c
+func New(c *cache.Cache, key string, source Source) *Adapter {
+	return &Adapter{
+		cache:  c,
+		key:    key,
+		source: source,
+	}
+}
+
+// Get returns one configuration value
+func (p *Adapter) Get(name string) (interface{}, bool) {
+	v, found := p.cache.Get(p.key)
+	if !found {
+		return nil, false
+	}
+
+	value, found := v.(map[string]interface{})[name]
+	return value, found
+}
+
+// Watch registers fn to be called after each reload that changed, added or
+// removed values, with the sorted names of those values
+func (p *Adapter) Watch(fn func(changed []string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.subscribers = append(p.subscribers, fn)
+}
+
+// Reload loads the Source and swaps the stored configuration. On error the
+// current configuration is kept.
+func (p *Adapter) Reload() error {
+	config, err := p.source.Load()
+	if err != nil {
+		return err
+	}
+
+	previous, _, err := p.cache.Swap(p.key, config, cache.NoExpiration)
+	if err != nil {
+		return err
+	}
+
+	old, _ := previous.(map[string]interface{})
+	changed := diff(old, config)
+	if len(changed) == 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	subscribers := append([]func([]string){}, p.subscribers...)
+	p.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(changed)
+	}
+
+	return nil
+}
+
+// Run reloads every interval until stop is closed. Load errors keep the
+// current configuration and are passed to onError when it isn't nil.
+func (p *Adapter) Run(interval time.Duration, stop <-chan struct{}, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.Reload(); err != nil && onError != nil {
+				onError(err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// diff returns the sorted names whose values differ between old and new
+func diff(old, new map[string]interface{}) []string {
+	var changed []string
+	for name, value := range new {
+		if current, found := old[name]; !found || !reflect.DeepEqual(current, value) {
+			changed = append(changed, name)
+		}
+	}
+
+	for name := range old {
+		if _, found := new[name]; !found {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+
+	return changed
+}
@@ -0,0 +1,51 @@
+package configcache
+
+import (
+	"errors"
+	"testing"
+
+	cache "github.com/manhcuongincusar1/pointer-cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReload(t *testing.T) {
+	c, err := cache.New(&cache.Option{MemoryLimit: 222222}, nil)
+	assert.Nil(t, err)
+
+	var (
+		config  = map[string]interface{}{"timeout": 5, "host": "a"}
+		loadErr error
+	)
+	adapter := New(c, "config", SourceFunc(func() (map[string]interface{}, error) {
+		return config, loadErr
+	}))
+
+	var notified [][]string
+	adapter.Watch(func(changed []string) {
+		notified = append(notified, changed)
+	})
+
+	assert.Nil(t, adapter.Reload())
+	v, found := adapter.Get("timeout")
+	assert.True(t, found)
+	assert.Equal(t, 5, v)
+
+	// Unchanged values don't notify
+	assert.Nil(t, adapter.Reload())
+
+	config = map[string]interface{}{"timeout": 10, "port": 80}
+	assert.Nil(t, adapter.Reload())
+	_, found = adapter.Get("host")
+	assert.False(t, found)
+
+	// A failing source keeps the current configuration
+	loadErr = errors.New("unreachable")
+	assert.NotNil(t, adapter.Reload())
+	v, _ = adapter.Get("timeout")
+	assert.Equal(t, 10, v)
+
+	assert.Equal(t, [][]string{
+		{"host", "timeout"},
+		{"host", "port", "timeout"},
+	}, notified)
+}
@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"errors"
+	"time"
+
+	keymanager "github.com/manhcuongincusar1/pointer-cache/key_manager"
+)
+
+// CanStore reports what Set(k, v, ...) would do right now without changing
+// anything: the size v would be charged, the keys that would be evicted to
+// make room, in order, and the error the write would fail with.
+// Predictions follow the key manager's order; FairEviction and
+// EvictionBudget are not taken into account.
+func (p *cache) CanStore(k string, v interface{}) (int64, []string, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	size, ok := p.measureItem(k, v, p.option.MaxItemSize)
+	if !ok || (p.option.MaxItemSize > 0 && size > p.option.MaxItemSize) {
+		return size, nil, ErrItemTooLarge
+	}
+
+	// The current item is detached before making room, as in store
+	var freed int64
+	count := len(p.items)
+	if old, exists := p.items[k]; exists {
+		freed = old.Mem
+		count--
+	}
+
+	if err := p.checkQuota(k, size-freed); err != nil {
+		return size, nil, err
+	}
+
+	wouldEvict, err := p.predictEvictions(k, count, p.memUsage-freed, size)
+	return size, wouldEvict, err
+}
+
+// predictEvictions mirrors evict for a write of size bytes over count items
+// using memUsage bytes, with k detached
+func (p *cache) predictEvictions(k string, count int, memUsage, size int64) ([]string, error) {
+	if err := p.fits(1, size); err != nil {
+		return nil, err
+	}
+
+	full := func() bool {
+		return (p.option.Capacity > 0 && count >= p.option.Capacity) ||
+			(p.option.MemoryLimit > 0 && memUsage+size > p.option.MemoryLimit)
+	}
+
+	var victims []string
+	remove := func(key string) {
+		victims = append(victims, key)
+		count--
+		memUsage -= p.items[key].Mem
+	}
+
+	policy := p.option.FullPolicy
+	if policy == EvictOldest && p.option.KeyManagerType == keymanager.None {
+		policy = RejectNew
+	}
+
+	switch policy {
+	case RejectNew:
+		if full() {
+			return nil, ErrCacheFull
+		}
+
+		return nil, nil
+
+	case EvictExpiredOnly:
+		if !full() {
+			return nil, nil
+		}
+
+		for _, key := range p.expiringBefore(time.Now().UnixNano()) {
+			if _, found := p.items[key]; found && key != k {
+				remove(key)
+			}
+		}
+
+		if full() {
+			return nil, ErrCacheFull
+		}
+
+		return victims, nil
+	}
+
+	if !full() {
+		return nil, nil
+	}
+
+	lister, ok := p.keyManager.(keyLister)
+	if !ok {
+		return nil, errors.New("key manager can't list its eviction order")
+	}
+
+	order := append(p.untrackedKeys(), lister.GetValues()...)
+	next := func() (string, bool) {
+		for len(order) > 0 {
+			key := order[0]
+			order = order[1:]
+			if _, found := p.items[key]; found && key != k {
+				return key, true
+			}
+		}
+
+		return "", false
+	}
+
+	for p.option.Capacity > 0 && count >= p.option.Capacity {
+		key, ok := next()
+		if !ok {
+			return nil, ErrCacheFull
+		}
+		remove(key)
+	}
+
+	for p.option.MemoryLimit > 0 && memUsage+size > p.option.MemoryLimit {
+		key, ok := next()
+		if !ok {
+			return nil, ErrCacheFull
+		}
+		remove(key)
+	}
+
+	return victims, nil
+}
@@ -0,0 +1,121 @@
+package cache
+
+import "time"
+
+// EventType is what happened to a key in an Event
+type EventType int
+
+const (
+	// EventSet is a write of a new key, or over an expired item
+	EventSet EventType = iota
+	// EventReplace is a write over an unexpired item
+	EventReplace
+	// EventDelete is an explicit Delete or GetAndDelete
+	EventDelete
+	// EventExpire is an expired item being cleaned up
+	EventExpire
+	// EventEvict is a live item evicted to make room
+	EventEvict
+	// EventFlush is a Flush of the whole cache, with no key
+	EventFlush
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventReplace:
+		return "replace"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	case EventEvict:
+		return "evict"
+	case EventFlush:
+		return "flush"
+	}
+
+	return "unknown"
+}
+
+// Event is an entry of the recent events buffer
+type Event struct {
+	Type EventType
+	Key  string
+	Time time.Time
+}
+
+// ring keeps the last len(entries) entries, overwriting the oldest ones.
+// It is written under the cache's write lock.
+type ring[T any] struct {
+	entries []T
+	next    int
+	full    bool
+}
+
+func newRing[T any](size int) ring[T] {
+	return ring[T]{entries: make([]T, size)}
+}
+
+func (r *ring[T]) enabled() bool {
+	return len(r.entries) > 0
+}
+
+func (r *ring[T]) push(v T) {
+	if len(r.entries) == 0 {
+		return
+	}
+
+	r.entries[r.next] = v
+	r.next++
+	if r.next == len(r.entries) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// last returns up to n of the most recent entries, oldest first
+func (r *ring[T]) last(n int) []T {
+	size := r.next
+	if r.full {
+		size = len(r.entries)
+	}
+	if n <= 0 || n > size {
+		n = size
+	}
+
+	entries := make([]T, n)
+	start := r.next - n
+	if start < 0 {
+		start += len(r.entries)
+	}
+	for i := range entries {
+		entries[i] = r.entries[(start+i)%len(r.entries)]
+	}
+
+	return entries
+}
+
+// eventRing is the ring of recent events
+type eventRing struct {
+	ring[Event]
+}
+
+func (r *eventRing) record(t EventType, k string) {
+	if !r.enabled() {
+		return
+	}
+
+	r.push(Event{Type: t, Key: k, Time: time.Now()})
+}
+
+// RecentEvents returns up to n of the most recent events, oldest first, or
+// all the buffered ones if n <= 0. Only Option.EventBufferSize events are
+// kept; with no buffer it returns nothing.
+func (p *cache) RecentEvents(n int) []Event {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.events.last(n)
+}
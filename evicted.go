@@ -0,0 +1,32 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// callOnEvicted runs fn, the OnEvicted callback, for k and v. Calls longer
+// than Option.SlowOnEvicted are counted, and with Option.AsyncOnEvicted the
+// first of them switches every later call to its own goroutine.
+func (p *cache) callOnEvicted(fn func(string, interface{}), k string, v interface{}) {
+	if atomic.LoadInt32(&p.evictedAsync) == 1 {
+		go p.safely(func() { fn(k, v) })
+		return
+	}
+
+	if p.option.SlowOnEvicted <= 0 {
+		p.safely(func() { fn(k, v) })
+		return
+	}
+
+	start := time.Now()
+	p.safely(func() { fn(k, v) })
+	if time.Since(start) <= p.option.SlowOnEvicted {
+		return
+	}
+
+	atomic.AddInt64(&p.slowEvicted, 1)
+	if p.option.AsyncOnEvicted {
+		atomic.StoreInt32(&p.evictedAsync, 1)
+	}
+}
@@ -0,0 +1,115 @@
+package cache
+
+// KV is a key and its value, as passed to OnEvictedBatch
+type KV struct {
+	Key   string
+	Value interface{}
+}
+
+// EvictionReason is why the items of an OnEvictedBatch call were removed
+type EvictionReason int
+
+const (
+	// EvictionExpired is a cleanup of expired items
+	EvictionExpired EvictionReason = iota
+	// EvictionCapacity is a write making room under Capacity
+	EvictionCapacity
+	// EvictionMemory is a write making room under MemoryLimit
+	EvictionMemory
+	// EvictionDeleted is a Delete or GetAndDelete
+	EvictionDeleted
+)
+
+func (r EvictionReason) String() string {
+	switch r {
+	case EvictionExpired:
+		return "expired"
+	case EvictionCapacity:
+		return "capacity"
+	case EvictionMemory:
+		return "memory"
+	case EvictionDeleted:
+		return "deleted"
+	}
+
+	return "unknown"
+}
+
+// evictionBatch is the victims of one reason in a pass
+type evictionBatch struct {
+	reason EvictionReason
+	items  []KV
+}
+
+// OnEvictedBatch sets an (optional) function called once per eviction pass
+// with all the items it removed, e.g. every expired item of a DeleteExpired
+// or every item evicted to make room for a write, after the lock is
+// released. Unlike OnEvicted it also covers items evicted for room. A pass
+// evicting for both Capacity and MemoryLimit makes one call for each. Set to
+// nil to disable.
+func (p *cache) OnEvictedBatch(f func([]KV, EvictionReason)) {
+	p.mu.Lock()
+	p.onEvictedBatch = f
+	p.mu.Unlock()
+}
+
+// recordEviction adds k to the pending batch for reason. Called under the
+// write lock, before k is deleted.
+func (p *cache) recordEviction(reason EvictionReason, k string) {
+	if p.onEvictedBatch == nil {
+		return
+	}
+
+	item, found := p.items[k]
+	if !found {
+		return
+	}
+
+	n := len(p.evictions)
+	if n == 0 || p.evictions[n-1].reason != reason {
+		p.evictions = append(p.evictions, evictionBatch{reason: reason})
+		n++
+	}
+	p.evictions[n-1].items = append(p.evictions[n-1].items, KV{k, item.Object})
+}
+
+// pendingEvictions are the batches recorded so far and the callback to
+// pass them to, with the expired items a write removed for OnEvicted,
+// taken under the write lock
+type pendingEvictions struct {
+	batches []evictionBatch
+	fn      func([]KV, EvictionReason)
+
+	expired   []keyAndValue
+	onEvicted func(string, interface{})
+}
+
+func (p *cache) takeEvictions() pendingEvictions {
+	pending := pendingEvictions{
+		batches:   p.evictions,
+		fn:        p.onEvictedBatch,
+		expired:   p.expired,
+		onEvicted: p.onEvicted,
+	}
+	p.evictions = nil
+	p.expired = nil
+
+	return pending
+}
+
+// fireEvictions calls OnEvictedBatch, then OnEvicted for the expired items,
+// once the lock is released
+func (p *cache) fireEvictions(pending pendingEvictions) {
+	if pending.fn != nil {
+		for _, batch := range pending.batches {
+			batch := batch
+			p.safely(func() { pending.fn(batch.items, batch.reason) })
+		}
+	}
+
+	if pending.onEvicted != nil {
+		for _, kv := range pending.expired {
+			p.callOnEvicted(pending.onEvicted, kv.key, kv.value)
+		}
+	}
+}
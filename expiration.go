@@ -0,0 +1,33 @@
+package cache
+
+import "time"
+
+// Expiration is how long an item lives, as taken by Set and the other
+// writes. It is an alias of time.Duration, so the helpers below and plain
+// durations are accepted interchangeably.
+type Expiration = time.Duration
+
+// In expires an item after d. Unlike a raw duration, a d of zero or less is
+// not read as Default or Never: the item expires right away.
+func In(d time.Duration) Expiration {
+	if d <= 0 {
+		return time.Nanosecond
+	}
+
+	return d
+}
+
+// At expires an item at t
+func At(t time.Time) Expiration {
+	return In(time.Until(t))
+}
+
+// Never keeps an item until it is deleted or evicted
+func Never() Expiration {
+	return NoExpiration
+}
+
+// Default uses the cache's Option.DefaultExpiration
+func Default() Expiration {
+	return DefaultExpiration
+}
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+// expirationEntry is a key with an expiration in the expiration heap
+type expirationEntry struct {
+	key        string
+	expiration int64
+	index      int
+}
+
+// expirationHeap is a min-heap of the items that expire, soonest first with
+// ties ordered by Option.Tiebreak. It implements heap.Interface; use the
+// cache's schedule/unschedule helpers.
+type expirationHeap struct {
+	entries  []*expirationEntry
+	tiebreak func(a, b string) bool // Option.Tiebreak, nil for key order
+}
+
+func (h *expirationHeap) Len() int { return len(h.entries) }
+
+func (h *expirationHeap) Less(i, j int) bool {
+	a, b := h.entries[i], h.entries[j]
+	if a.expiration != b.expiration {
+		return a.expiration < b.expiration
+	}
+
+	if h.tiebreak != nil {
+		return h.tiebreak(a.key, b.key)
+	}
+
+	return a.key < b.key
+}
+
+func (h *expirationHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].index = i
+	h.entries[j].index = j
+}
+
+func (h *expirationHeap) Push(x any) {
+	entry := x.(*expirationEntry)
+	entry.index = len(h.entries)
+	h.entries = append(h.entries, entry)
+}
+
+func (h *expirationHeap) Pop() any {
+	old := h.entries
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.index = -1
+	h.entries = old[:n-1]
+
+	return entry
+}
+
+// scheduleExpiration records when k expires, removing it from the heap when
+// it doesn't expire (e == 0)
+func (p *cache) scheduleExpiration(k string, e int64) {
+	entry, found := p.expirationIndex[k]
+	if e == 0 {
+		if found {
+			heap.Remove(&p.expirations, entry.index)
+			delete(p.expirationIndex, k)
+		}
+
+		return
+	}
+
+	if found {
+		entry.expiration = e
+		heap.Fix(&p.expirations, entry.index)
+		return
+	}
+
+	if p.expirationIndex == nil {
+		p.expirationIndex = make(map[string]*expirationEntry)
+	}
+
+	entry = &expirationEntry{key: k, expiration: e}
+	heap.Push(&p.expirations, entry)
+	p.expirationIndex[k] = entry
+}
+
+// unscheduleExpiration forgets k, e.g. once it is deleted
+func (p *cache) unscheduleExpiration(k string) {
+	p.scheduleExpiration(k, 0)
+}
+
+// NextExpiration returns when the next item expires, and false if no item
+// has an expiration. Items already expired but not cleaned up yet count.
+func (p *cache) NextExpiration() (time.Time, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.expirations.Len() == 0 {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, p.expirations.entries[0].expiration), true
+}
+
+// ExpiringBefore returns the keys of the items expiring before t, soonest
+// first with ties ordered by Option.Tiebreak. Items already expired but not
+// cleaned up yet are included.
+func (p *cache) ExpiringBefore(t time.Time) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.expiringBefore(t.UnixNano())
+}
+
+func (p *cache) expiringBefore(cutoff int64) []string {
+	var entries []*expirationEntry
+	for _, entry := range p.expirations.entries {
+		if entry.expiration < cutoff {
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].expiration != entries[j].expiration {
+			return entries[i].expiration < entries[j].expiration
+		}
+
+		return p.tiebreak(entries[i].key, entries[j].key)
+	})
+
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		keys[i] = entry.key
+	}
+
+	return keys
+}
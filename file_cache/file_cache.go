@@ -0,0 +1,71 @@
+package filecache
+
+import (
+	"os"
+	"time"
+
+	cache "github.com/manhcuongincusar1/pointer-cache"
+)
+
+// FileCache caches file contents keyed by path. Every read stats the file
+// and reloads it when its modification time or size changed, so edits are
+// picked up without any watcher.
+type FileCache struct {
+	cache *cache.Cache
+	ttl   time.Duration
+}
+
+// New creates a FileCache on top of c. Contents are kept for ttl after they
+// were read; use cache.NoExpiration to keep them until evicted.
+func New(c *cache.Cache, ttl time.Duration) *FileCache {
+	return &FileCache{
+		cache: c,
+		ttl:   ttl,
+	}
+}
+
+type entry struct {
+	modTime time.Time
+	size    int64
+	data    []byte
+}
+
+// ReadFile returns the contents of the named file like os.ReadFile.
+// The returned slice is shared with the cache and must not be modified.
+func (p *FileCache) ReadFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		p.cache.Delete(cacheKey(path))
+		return nil, err
+	}
+
+	if v, found := p.cache.Get(cacheKey(path)); found {
+		e := v.(entry)
+		if e.modTime.Equal(info.ModTime()) && e.size == info.Size() {
+			return e.data, nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// A failed Set (e.g. the file is bigger than the cache) still returns the data
+	p.cache.Set(cacheKey(path), entry{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		data:    data,
+	}, p.ttl)
+
+	return data, nil
+}
+
+// Invalidate drops the cached contents of the named file
+func (p *FileCache) Invalidate(path string) {
+	p.cache.Delete(cacheKey(path))
+}
+
+func cacheKey(path string) string {
+	return "file:" + path
+}
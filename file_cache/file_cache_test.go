@@ -0,0 +1,43 @@
+package filecache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cache "github.com/manhcuongincusar1/pointer-cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadFile(t *testing.T) {
+	c, err := cache.New(&cache.Option{MemoryLimit: 222222}, nil)
+	assert.Nil(t, err)
+
+	fc := New(c, cache.NoExpiration)
+	path := filepath.Join(t.TempDir(), "config.txt")
+	assert.Nil(t, os.WriteFile(path, []byte("v1"), 0o644))
+
+	data, err := fc.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", string(data))
+	assert.Equal(t, 1, c.Size())
+
+	t.Run("Reload after a change", func(t *testing.T) {
+		assert.Nil(t, os.WriteFile(path, []byte("v2!"), 0o644))
+		later := time.Now().Add(time.Second)
+		assert.Nil(t, os.Chtimes(path, later, later))
+
+		data, err := fc.ReadFile(path)
+		assert.Nil(t, err)
+		assert.Equal(t, "v2!", string(data))
+	})
+
+	t.Run("Removed file", func(t *testing.T) {
+		assert.Nil(t, os.Remove(path))
+
+		_, err := fc.ReadFile(path)
+		assert.True(t, os.IsNotExist(err))
+		assert.Equal(t, 0, c.Size())
+	})
+}
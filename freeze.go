@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// FrozenEviction is an item a frozen cache would have removed
+type FrozenEviction struct {
+	Key    string
+	Reason EvictionReason
+}
+
+// frozen is the state of a cache between FreezeEviction and ThawEviction.
+// Items that would have been removed are held: they stay in the cache but
+// no longer count against Capacity or MemoryLimit, as if they were gone.
+type frozen struct {
+	held    map[string]bool
+	evicted []FrozenEviction
+}
+
+// FreezeEviction stops the cache from removing items to make room or as
+// they expire, until ThawEviction. Writes that would have evicted items
+// succeed and keep them, so the cache may go over Capacity and MemoryLimit,
+// and DeleteExpired and the janitor remove nothing. Reads still miss
+// expired items. It is meant for tests, see the cachetest package.
+func (p *cache) FreezeEviction() {
+	p.mu.Lock()
+	if p.frozen == nil {
+		p.frozen = &frozen{held: make(map[string]bool)}
+	}
+	p.mu.Unlock()
+}
+
+// ThawEviction lets the cache remove items again and returns, in order,
+// the ones it would have removed since FreezeEviction and still holds.
+// They are left in place for the next eviction pass to take.
+func (p *cache) ThawEviction() []FrozenEviction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.frozen == nil {
+		return nil
+	}
+
+	evicted := p.frozen.evicted[:0]
+	for _, e := range p.frozen.evicted {
+		if p.frozen.held[e.Key] {
+			evicted = append(evicted, e)
+		}
+	}
+	p.frozen = nil
+
+	return evicted
+}
+
+// hold records k as removed for reason without removing it
+func (p *cache) hold(k string, reason EvictionReason) {
+	p.frozen.held[k] = true
+	p.frozen.evicted = append(p.frozen.evicted, FrozenEviction{k, reason})
+}
+
+// release takes k, deleted or replaced for real, back from the held items
+func (p *cache) release(k string) {
+	if p.frozen != nil {
+		delete(p.frozen.held, k)
+	}
+}
+
+// holdExpired is deleteExpired on a frozen cache
+func (p *cache) holdExpired(now int64) {
+	for _, k := range p.expiringBefore(now) {
+		if _, found := p.items[k]; found && !p.frozen.held[k] {
+			p.hold(k, EvictionExpired)
+		}
+	}
+}
+
+// frozenEvict is evict on a frozen cache: it holds the items evict would
+// have removed, counting what is held as gone
+func (p *cache) frozenEvict(policy FullPolicy, n int, size int64) error {
+	count, memUsage := len(p.items), p.memUsage
+	for k := range p.frozen.held {
+		count--
+		memUsage -= p.items[k].Mem
+	}
+
+	full := func() bool {
+		return (p.option.Capacity > 0 && count+n > p.option.Capacity) ||
+			(p.option.MemoryLimit > 0 && memUsage+size > p.option.MemoryLimit)
+	}
+
+	hold := func(k string, reason EvictionReason) {
+		p.hold(k, reason)
+		count--
+		memUsage -= p.items[k].Mem
+	}
+
+	if policy == EvictExpiredOnly {
+		if full() {
+			for _, k := range p.expiringBefore(time.Now().UnixNano()) {
+				if _, found := p.items[k]; found && !p.frozen.held[k] {
+					hold(k, EvictionExpired)
+				}
+			}
+		}
+
+		if full() {
+			return ErrCacheFull
+		}
+
+		return nil
+	}
+
+	if !full() {
+		return nil
+	}
+
+	lister, ok := p.keyManager.(keyLister)
+	if !ok {
+		return errors.New("key manager can't list its eviction order")
+	}
+
+	order := append(p.untrackedKeys(), lister.GetValues()...)
+	next := func() (string, bool) {
+		for len(order) > 0 {
+			k := order[0]
+			order = order[1:]
+			if _, found := p.items[k]; found && !p.frozen.held[k] {
+				return k, true
+			}
+		}
+
+		return "", false
+	}
+
+	for p.option.Capacity > 0 && count+n > p.option.Capacity {
+		k, ok := next()
+		if !ok {
+			return ErrCacheFull
+		}
+		hold(k, EvictionCapacity)
+	}
+
+	for p.option.MemoryLimit > 0 && memUsage+size > p.option.MemoryLimit {
+		k, ok := next()
+		if !ok {
+			return ErrCacheFull
+		}
+		hold(k, EvictionMemory)
+	}
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package cache
+
+import "time"
+
+// SetWithFreshness stores an item that is fresh for the first duration and
+// valid for the second. Between the two, GetWithFreshness still returns it
+// but reports it as stale so the caller can refresh it in the background;
+// after validity it is a miss like any expired item. Get ignores freshness.
+func (p *cache) SetWithFreshness(k string, v interface{}, fresh, valid time.Duration) error {
+	_, _, err := p.write(k, v, valid, nil, &writeOptions{fresh: fresh})
+	return err
+}
+
+// GetWithFreshness returns an item, whether it is stale and whether it was
+// found. Items stored without a freshness stay fresh until they expire.
+func (p *cache) GetWithFreshness(k string) (interface{}, bool, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	item, found := p.getItem(k)
+	p.countLookup(k, found)
+	if !found {
+		return nil, false, false
+	}
+	p.access(k)
+
+	stale := item.Fresh > 0 && p.now() > item.Fresh
+	return item.Object, stale, true
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets covers 1ns up to ~4.5 minutes in powers of two
+const latencyBuckets = 39
+
+// latencyHistogram counts observations in power-of-two buckets.
+// It is updated with atomics so Get can record under the read lock.
+type latencyHistogram struct {
+	counts [latencyBuckets]uint64
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	i := bits.Len64(uint64(d))
+	if i >= latencyBuckets {
+		i = latencyBuckets - 1
+	}
+
+	atomic.AddUint64(&h.counts[i], 1)
+}
+
+func (h *latencyHistogram) snapshot() LatencyHistogram {
+	var s LatencyHistogram
+	for i := range h.counts {
+		s.Counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+
+	return s
+}
+
+// LatencyHistogram is a copy of an operation's latency distribution.
+// Counts[0] holds zero durations and Counts[i] holds durations in
+// [2^(i-1), 2^i) nanoseconds; the last bucket also takes everything slower.
+type LatencyHistogram struct {
+	Counts [latencyBuckets]uint64
+}
+
+// Total returns the number of recorded operations
+func (h LatencyHistogram) Total() uint64 {
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+
+	return total
+}
+
+// Quantile returns the upper bound of the bucket holding the q-th quantile,
+// e.g. Quantile(0.99) for the p99 latency. It returns 0 when nothing was recorded.
+func (h LatencyHistogram) Quantile(q float64) time.Duration {
+	total := h.Total()
+	if total == 0 {
+		return 0
+	}
+
+	rank := uint64(q * float64(total))
+	if rank >= total {
+		rank = total - 1
+	}
+
+	var seen uint64
+	for i, c := range h.Counts {
+		seen += c
+		if seen > rank {
+			return time.Duration(uint64(1) << uint(i))
+		}
+	}
+
+	return time.Duration(uint64(1) << uint(latencyBuckets-1))
+}
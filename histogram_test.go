@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	var h latencyHistogram
+
+	for i := 0; i < 99; i++ {
+		h.observe(100 * time.Nanosecond)
+	}
+	h.observe(time.Millisecond)
+
+	s := h.snapshot()
+	assert.Equal(t, uint64(100), s.Total())
+	assert.Equal(t, 128*time.Nanosecond, s.Quantile(0.5))
+	assert.True(t, s.Quantile(1) >= time.Millisecond)
+	assert.Equal(t, time.Duration(0), LatencyHistogram{}.Quantile(0.99))
+}
+
+func TestTrackLatency(t *testing.T) {
+	c, err := New(&Option{
+		MemoryLimit:  222222,
+		TrackLatency: true,
+	}, nil)
+
+	assert.Nil(t, err)
+
+	c.Set("a", 1, NoExpiration)
+	c.Get("a")
+	c.Get("b")
+	c.DeleteExpired()
+
+	stats := c.Stats()
+	assert.Equal(t, uint64(1), stats.SetLatency.Total())
+	assert.Equal(t, uint64(2), stats.GetLatency.Total())
+	assert.Equal(t, uint64(1), stats.EvictionLatency.Total())
+}
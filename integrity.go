@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// Report is the result of CheckIntegrity
+type Report struct {
+	Items    int   // items in the map
+	Count    int   // item counter behind Size
+	MemUsage int64 // memory usage behind Alloc
+	ItemsMem int64 // sum of the items' sizes
+
+	// Keys held by the cache but not by the key manager, and the other way
+	// round. Both are left empty when the key manager can't list its keys.
+	MissingKeys []string
+	StaleKeys   []string
+	// Keys the key manager holds more than once
+	DuplicateKeys []string
+}
+
+// OK reports whether no drift was found
+func (r Report) OK() bool {
+	return r.Items == r.Count &&
+		r.MemUsage == r.ItemsMem &&
+		len(r.MissingKeys) == 0 &&
+		len(r.StaleKeys) == 0 &&
+		len(r.DuplicateKeys) == 0
+}
+
+// CheckIntegrity verifies that the counters match the items and that the key
+// manager holds exactly the cached keys
+func (p *cache) CheckIntegrity() Report {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.checkIntegrity()
+}
+
+func (p *cache) checkIntegrity() Report {
+	r := Report{
+		Items:    len(p.items),
+		Count:    int(atomic.LoadInt64(&p.count)),
+		MemUsage: atomic.LoadInt64(&p.memUsage),
+	}
+
+	for _, item := range p.items {
+		r.ItemsMem += item.Mem
+	}
+
+	lister, ok := p.keyManager.(keyLister)
+	if !ok {
+		return r
+	}
+
+	managed := make(map[string]int, len(p.items))
+	for _, k := range lister.GetValues() {
+		managed[k]++
+	}
+
+	for k, n := range managed {
+		if _, found := p.items[k]; !found {
+			r.StaleKeys = append(r.StaleKeys, k)
+		}
+
+		if n > 1 {
+			r.DuplicateKeys = append(r.DuplicateKeys, k)
+		}
+	}
+
+	// Keys over KeyManagerCapacity are left untracked on purpose
+	for k := range p.items {
+		if _, found := managed[k]; !found && !p.limitsKeys() {
+			r.MissingKeys = append(r.MissingKeys, k)
+		}
+	}
+
+	sort.Strings(r.StaleKeys)
+	sort.Strings(r.DuplicateKeys)
+	sort.Strings(r.MissingKeys)
+
+	return r
+}
+
+// repairIntegrity brings the counters and the key manager back in line with
+// the items and returns the drift it found. Missing keys go to the back of
+// the key manager and duplicates keep only their newest position.
+func (p *cache) repairIntegrity() Report {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	r := p.checkIntegrity()
+	if r.OK() {
+		return r
+	}
+
+	atomic.StoreInt64(&p.count, int64(r.Items))
+	atomic.StoreInt64(&p.memUsage, 0)
+	p.quotaUsage = nil
+	for k, item := range p.items {
+		p.addMemUsage(k, item.Mem)
+	}
+
+	for _, k := range r.StaleKeys {
+		p.keyManager.Delete(k)
+	}
+
+	for _, k := range r.MissingKeys {
+		p.touchKey(k)
+	}
+
+	if len(r.DuplicateKeys) > 0 {
+		// Delete drops the oldest occurrence, so drop all but one
+		managed := make(map[string]int, len(r.DuplicateKeys))
+		for _, k := range p.keyManager.(keyLister).GetValues() {
+			managed[k]++
+		}
+
+		for _, k := range r.DuplicateKeys {
+			for i := 1; i < managed[k]; i++ {
+				p.keyManager.Delete(k)
+			}
+		}
+	}
+
+	return r
+}
@@ -0,0 +1,196 @@
+package keymanager
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultHalfLife is the half-life of the "decay" KeyManagerType
+const DefaultHalfLife = time.Hour
+
+// NewDecay returns a key manager evicting the key with the lowest access
+// score, where every access adds 1 and scores halve every halfLife. Unlike
+// FIFO or LRU, popularity that shifts over hours moves keys gradually.
+func NewDecay(halfLife time.Duration) KeyManager {
+	if halfLife <= 0 {
+		halfLife = DefaultHalfLife
+	}
+
+	return &decay{
+		halfLife: float64(halfLife),
+		origin:   time.Now(),
+		entries:  make(map[string]*decayEntry),
+		now:      time.Now,
+	}
+}
+
+// decayEntry keeps its score as log2(score) plus the half-lives elapsed
+// since origin, which orders keys the same way at any later time, so scores
+// never need to be decayed in place
+type decayEntry struct {
+	key   string
+	rank  float64
+	index int
+}
+
+type decayHeap []*decayEntry
+
+func (h decayHeap) Len() int { return len(h) }
+
+func (h decayHeap) Less(i, j int) bool {
+	if h[i].rank != h[j].rank {
+		return h[i].rank < h[j].rank
+	}
+
+	return h[i].key < h[j].key
+}
+
+func (h decayHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *decayHeap) Push(x any) {
+	entry := x.(*decayEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *decayHeap) Pop() any {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+
+	return entry
+}
+
+type decay struct {
+	mu       sync.Mutex
+	halfLife float64
+	origin   time.Time
+	entries  map[string]*decayEntry
+	heap     decayHeap
+	now      func() time.Time
+}
+
+// elapsed is the number of half-lives since origin
+func (p *decay) elapsed() float64 {
+	return float64(p.now().Sub(p.origin)) / p.halfLife
+}
+
+// access adds 1 to the score of key, adding it if missing
+func (p *decay) access(key string) {
+	t := p.elapsed()
+	entry, found := p.entries[key]
+	if !found {
+		entry = &decayEntry{key: key, rank: t}
+		heap.Push(&p.heap, entry)
+		p.entries[key] = entry
+		return
+	}
+
+	score := math.Exp2(entry.rank - t)
+	entry.rank = math.Log2(score+1) + t
+	heap.Fix(&p.heap, entry.index)
+}
+
+// Add counts as an access of the key
+func (p *decay) Add(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.access(key)
+	return true
+}
+
+// Access is called by the cache on every read of the key
+func (p *decay) Access(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, found := p.entries[key]; found {
+		p.access(key)
+	}
+}
+
+// Touch counts a write as an access
+func (p *decay) Touch(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.access(key)
+}
+
+func (p *decay) Delete(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, found := p.entries[key]
+	if !found {
+		return
+	}
+
+	heap.Remove(&p.heap, entry.index)
+	delete(p.entries, key)
+}
+
+func (p *decay) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.entries)
+}
+
+// Score returns the current score of key
+func (p *decay) Score(key string) (float64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, found := p.entries[key]
+	if !found {
+		return 0, false
+	}
+
+	return math.Exp2(entry.rank - p.elapsed()), true
+}
+
+// Peek returns the key with the lowest score
+func (p *decay) Peek() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.heap) == 0 {
+		return "", errEmptyQueue
+	}
+
+	return p.heap[0].key, nil
+}
+
+// GetValues returns the keys in eviction order, lowest score first
+func (p *decay) GetValues() []string {
+	p.mu.Lock()
+	entries := append(decayHeap(nil), p.heap...)
+	p.mu.Unlock()
+
+	sort.Slice(entries, entries.Less)
+
+	values := make([]string, len(entries))
+	for i, entry := range entries {
+		values[i] = entry.key
+	}
+	return values
+}
+
+func (p *decay) Clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.entries = make(map[string]*decayEntry)
+	p.heap = nil
+}
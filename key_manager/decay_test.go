@@ -0,0 +1,46 @@
+package keymanager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecay(t *testing.T) {
+	km := NewDecay(time.Minute).(*decay)
+	now := km.origin
+	km.now = func() time.Time { return now }
+
+	_, err := km.Peek()
+	assert.NotNil(t, err)
+
+	km.Add("a")
+	km.Add("b")
+	km.Access("a")
+	km.Access("a")
+	// Unknown keys aren't added by reads
+	km.Access("c")
+	assert.Equal(t, 2, km.Size())
+	score, found := km.Score("a")
+	assert.True(t, found)
+	assert.InDelta(t, 3, score, 1e-9)
+
+	key, err := km.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, "b", key)
+
+	// Ten half-lives later a's 3 accesses weigh less than b's fresh ones
+	now = now.Add(10 * time.Minute)
+	km.Access("b")
+	key, _ = km.Peek()
+	assert.Equal(t, "a", key)
+	assert.Equal(t, []string{"a", "b"}, km.GetValues())
+
+	km.Delete("a")
+	key, _ = km.Peek()
+	assert.Equal(t, "b", key)
+
+	km.Clear()
+	assert.Equal(t, 0, km.Size())
+}
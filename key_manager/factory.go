@@ -2,6 +2,14 @@ package keymanager
 
 import "errors"
 
+// None is the KeyManagerType with no eviction order: the cache then rejects
+// writes over its limits instead of evicting
+const None = "none"
+
+// Decay is the KeyManagerType ranking keys by decayed access scores, see
+// NewDecay
+const Decay = "decay"
+
 func NewKeyManager(holder string, size uint32) (KeyManager, error) {
 
 	if size == 0 {
@@ -12,5 +20,13 @@ func NewKeyManager(holder string, size uint32) (KeyManager, error) {
 		return NewQueue(size), nil
 	}
 
+	if holder == None {
+		return NewNoopManager(), nil
+	}
+
+	if holder == Decay {
+		return NewDecay(DefaultHalfLife), nil
+	}
+
 	return nil, errors.New("unsupported key manager")
 }
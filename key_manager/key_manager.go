@@ -1,8 +1,29 @@
 package keymanager
 
+// KeyManager decides which key the cache evicts next: Peek returns the
+// victim. The queue is strictly FIFO on write order, Set and Replace move a
+// key to the back, so two writes are never tied.
 type KeyManager interface {
 	Add(key string) bool
 	Size() int
 	Delete(key string)     // Delete the key
 	Peek() (string, error) // Take the first option
 }
+
+// Toucher is implemented by key managers that can move a key to the back,
+// adding it if missing. The cache falls back to Delete then Add for others.
+type Toucher interface {
+	Touch(key string)
+}
+
+// Accessor is implemented by key managers that rank keys by reads too: the
+// cache calls Access on every Get that finds the key
+type Accessor interface {
+	Access(key string)
+}
+
+// Scorer is implemented by key managers that rank keys by a score, the
+// lowest score being evicted first
+type Scorer interface {
+	Score(key string) (float64, bool)
+}
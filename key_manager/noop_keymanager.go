@@ -15,6 +15,7 @@ func (p *noop) Size() int {
 	return 0
 }
 func (p *noop) Delete(key string) {} // Delete the key
+func (p *noop) Touch(key string)  {} // Move the key to the back
 func (p *noop) Peek() (string, error) {
 	return "", nil
 } // Take the first option
@@ -27,15 +27,36 @@ type queue struct {
 	size  uint32
 	array []string
 	mu    sync.RWMutex
+
+	// members indexes the keys of array, which holds each key once. It is
+	// built on first use, see index.
+	members map[string]struct{}
+}
+
+// index builds members from array if needed
+func (p *queue) index() {
+	if p.members != nil {
+		return
+	}
+
+	p.members = make(map[string]struct{}, len(p.array))
+	for _, key := range p.array {
+		p.members[key] = struct{}{}
+	}
 }
 
 // Implement KeyManager
-// Add new key
+// Add new key. Adding a key already queued leaves it where it is.
 func (p *queue) Add(key string) (added bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if p.size != 0 && (p.Size() >= int(p.size)) {
+	p.index()
+	if _, found := p.members[key]; found {
+		return true
+	}
+
+	if p.size != 0 && (len(p.array) >= int(p.size)) {
 		return false
 	}
 	p.Enqueue(key)
@@ -58,7 +79,39 @@ func (p *queue) Delete(key string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	p.index()
+	if _, found := p.members[key]; !found {
+		return
+	}
+
 	p.array = remove(p.array, key)
+	delete(p.members, key)
+}
+
+// Contains reports whether key is in the queue
+func (p *queue) Contains(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.index()
+	_, found := p.members[key]
+	return found
+}
+
+// Touch moves the key to the back of the queue, as if it was just added
+func (p *queue) Touch(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.index()
+	if _, found := p.members[key]; found {
+		p.array = remove(p.array, key)
+		delete(p.members, key)
+	}
+	if p.size != 0 && (len(p.array) >= int(p.size)) {
+		return
+	}
+	p.Enqueue(key)
 }
 
 // Remove the oldest key
@@ -72,22 +125,51 @@ func (p *queue) Shift() (string, error) {
 
 // Size off current
 func (p *queue) Size() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
 	return len(p.array)
 }
 
-// Enqueue add to the Queue
+// Enqueue add to the Queue, skipping keys already in it
 func (p *queue) Enqueue(values ...string) {
-	p.array = append(p.array, values...)
+	p.index()
+	for _, value := range values {
+		if _, found := p.members[value]; found {
+			continue
+		}
+
+		p.array = append(p.array, value)
+		p.members[value] = struct{}{}
+	}
+}
+
+// Grow makes room for n more keys without reallocating
+func (p *queue) Grow(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if n <= cap(p.array)-len(p.array) {
+		return
+	}
+
+	arr := make([]string, len(p.array), len(p.array)+n)
+	copy(arr, p.array)
+	p.array = arr
 }
 
 // IsEmpty checks if the Queue is empty
 func (p *queue) IsEmpty() bool {
-	return p.Size() == 0
+	return len(p.array) == 0
 }
 
 // Clear clears Queue
 func (p *queue) Clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	p.array = nil
+	p.members = nil
 }
 
 // Dequeue remove from the Queue
@@ -96,8 +178,10 @@ func (p *queue) Dequeue() (res string, err error) {
 		return res, errEmptyQueue
 	}
 
+	p.index()
 	res = p.array[0]
 	p.array = p.array[1:]
+	delete(p.members, res)
 	return res, nil
 }
 
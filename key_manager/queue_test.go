@@ -136,3 +136,48 @@ func TestDelete(t *testing.T) {
 	q.Delete("3")
 	assert.True(t, reflect.DeepEqual(q.array, []string{"2", "4"}))
 }
+
+func TestQueue_Grow(t *testing.T) {
+	q := queue{}
+	q.Enqueue("key1")
+
+	q.Grow(100)
+	assert.True(t, cap(q.array) >= 101)
+	assert.True(t, reflect.DeepEqual(q.GetValues(), []string{"key1"}))
+}
+
+func TestTouch(t *testing.T) {
+	q := queue{
+		array: []string{"1", "2", "3"},
+	}
+
+	q.Touch("1")
+	assert.True(t, reflect.DeepEqual(q.array, []string{"2", "3", "1"}))
+
+	// Missing keys are added to the back
+	q.Touch("4")
+	assert.True(t, reflect.DeepEqual(q.array, []string{"2", "3", "1", "4"}))
+}
+
+func TestQueue_AddIsIdempotent(t *testing.T) {
+	km := NewQueue(0)
+
+	assert.True(t, km.Add("1"))
+	assert.True(t, km.Add("2"))
+	assert.True(t, km.Add("1"))
+	assert.Equal(t, 2, km.Size())
+
+	// The key keeps its place
+	key, _ := km.Peek()
+	assert.Equal(t, "1", key)
+
+	km.Delete("1")
+	key, _ = km.Peek()
+	assert.Equal(t, "2", key)
+	assert.Equal(t, 1, km.Size())
+
+	// Deleted keys can come back
+	km.Add("1")
+	km.(Toucher).Touch("2")
+	assert.Equal(t, []string{"1", "2"}, km.(*queue).GetValues())
+}
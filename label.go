@@ -0,0 +1,66 @@
+package cache
+
+import "sync/atomic"
+
+// LabelStats counts the traffic of the handles returned by WithLabel
+type LabelStats struct {
+	HitStats
+	Sets int64 // writes stored
+}
+
+// labelCounters are the atomic counters behind LabelStats
+type labelCounters struct {
+	hitCounters
+	sets int64
+}
+
+// WithLabel returns a handle on the same cache whose lookups and stored
+// writes are counted under label in Stats.Labels, to tell apart the callers
+// sharing a cache. Handles with the same label share their counters.
+func (c *Cache) WithLabel(label string) *Cache {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.labels == nil {
+		c.labels = make(map[string]*labelCounters)
+	}
+
+	counters, found := c.labels[label]
+	if !found {
+		counters = &labelCounters{}
+		c.labels[label] = counters
+	}
+
+	root := c.cache
+	if c.root != nil {
+		root = c.root
+	}
+
+	return &Cache{
+		cache: &cache{
+			cacheState: c.cacheState,
+			label:      counters,
+			root:       root,
+		},
+	}
+}
+
+// countLabelLookup counts a lookup under the handle's label, if it has one
+func (p *cache) countLabelLookup(hit bool) {
+	if p.label == nil {
+		return
+	}
+
+	if hit {
+		atomic.AddInt64(&p.label.hits, 1)
+	} else {
+		atomic.AddInt64(&p.label.misses, 1)
+	}
+}
+
+// countLabelSet counts a stored write under the handle's label, if it has one
+func (p *cache) countLabelSet() {
+	if p.label != nil {
+		atomic.AddInt64(&p.label.sets, 1)
+	}
+}
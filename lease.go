@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// defaultLeaseTTL is used when Option.LeaseTTL is zero
+const defaultLeaseTTL = 10 * time.Second
+
+// ErrInvalidLease is returned by LeaseSet when the lease was never granted,
+// has timed out or was invalidated by a write or delete of the key
+var ErrInvalidLease = errors.New("invalid lease")
+
+type lease struct {
+	token   uint64
+	expires int64
+}
+
+// LeaseGet works like memcached's lease get. A hit returns the value and a
+// zero token. On a miss the first caller is granted a lease token and is
+// expected to fill the key with LeaseSet; until it does (or the lease times
+// out) other callers get a zero token, plus the stale value when the
+// expired item hasn't been cleaned up yet, and should wait or use that.
+func (p *cache) LeaseGet(k string) (value interface{}, token uint64, found bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if v, found := p.get(k); found {
+		return v, 0, true
+	}
+
+	now := time.Now().UnixNano()
+	if l, held := p.leases[k]; held && l.expires > now {
+		if item, exists := p.items[k]; exists {
+			return item.Object, 0, false
+		}
+
+		return nil, 0, false
+	}
+
+	ttl := p.option.LeaseTTL
+	if ttl <= 0 {
+		ttl = defaultLeaseTTL
+	}
+
+	if p.leases == nil {
+		p.leases = make(map[string]lease)
+	}
+	p.leaseSeq++
+	p.leases[k] = lease{
+		token:   p.leaseSeq,
+		expires: now + int64(ttl),
+	}
+
+	return nil, p.leaseSeq, false
+}
+
+// LeaseSet stores a value with a token granted by LeaseGet. It fails with
+// ErrInvalidLease if another write or delete of the key happened since the
+// lease was granted, so a slow filler can't overwrite fresher data.
+func (p *cache) LeaseSet(k string, v interface{}, token uint64, d time.Duration) error {
+	_, _, err := p.write(k, v, d, func() error {
+		l, held := p.leases[k]
+		if !held || l.token != token || l.expires <= time.Now().UnixNano() {
+			return ErrInvalidLease
+		}
+
+		return nil
+	}, nil)
+
+	return err
+}
+
+// purgeLeases drops leases that timed out without being used
+func (p *cache) purgeLeases() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	for k, l := range p.leases {
+		if l.expires <= now {
+			delete(p.leases, k)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package cache
+
+import "time"
+
+// Merge stores v like Set, unless k has an unexpired item, in which case it
+// stores mergeFn(current, v) instead, in one step under the write lock
+// (e.g. to add to a running aggregate). The result is measured like any
+// written value, and OnReplaced and OnSet fire as for Set.
+func (p *cache) Merge(k string, v interface{}, mergeFn func(old, new interface{}) interface{}, d time.Duration) error {
+	if p.option.TrackLatency {
+		defer p.track(&p.latency.set, time.Now())
+	}
+
+	if err := p.throttle(nil); err != nil {
+		return err
+	}
+
+	merged, previous, replaced, hooks, err := p.merge(k, v, mergeFn, d)
+	p.fireEvictions(hooks.evictions)
+	if err != nil {
+		return err
+	}
+
+	p.fireWriteHooks(hooks, k, merged, previous, replaced)
+
+	return nil
+}
+
+// merge is the locked part of Merge. The lock is released on return even if
+// mergeFn panics.
+func (p *cache) merge(k string, v interface{}, mergeFn func(old, new interface{}) interface{}, d time.Duration) (merged, previous interface{}, replaced bool, hooks writeHooks, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	merged = v
+	if item, found := p.getItem(k); found {
+		merged = mergeFn(item.Object, v)
+	}
+
+	previous, replaced, err = p.set(k, merged, d, nil)
+	return merged, previous, replaced, p.writeHooks(), err
+}
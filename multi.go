@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"sort"
+	"time"
+)
+
+// GetMulti returns the unexpired values of keys found in the cache, taking
+// the read lock once. Missing and expired keys are left out of the map.
+func (p *cache) GetMulti(keys []string) map[string]interface{} {
+	values := make(map[string]interface{}, len(keys))
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	now := p.now()
+	for _, k := range keys {
+		item, found := p.items[k]
+		if !found || (item.Expiration > 0 && now > item.Expiration) {
+			p.countLookup(k, false)
+			continue
+		}
+
+		p.countLookup(k, true)
+		p.access(k)
+		values[k] = item.Object
+	}
+
+	return values
+}
+
+// ReadMulti calls fn with the unexpired value of every key of keys found in
+// the cache, in the order of keys, under one read lock, and returns how many
+// were found. As with Read, fn must not call the cache.
+func (p *cache) ReadMulti(keys []string, fn func(k string, v interface{})) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	found := 0
+	for _, k := range keys {
+		item, ok := p.getItem(k)
+		p.countLookup(k, ok)
+		if !ok {
+			continue
+		}
+
+		p.access(k)
+		fn(k, item.Object)
+		found++
+	}
+
+	return found
+}
+
+// batchWrite is one item of SetMulti
+type batchWrite struct {
+	k        string
+	v        interface{}
+	e, fresh int64
+	size     int64
+
+	stored   bool
+	previous interface{}
+	replaced bool
+}
+
+// SetMulti stores all of items with the TTL d, see Set, under one lock
+// acquisition, making room for the whole batch in a single eviction pass.
+// The items are measured first, so one over MaxItemSize fails the batch
+// before anything is written, as does a batch that doesn't fit. They are
+// then written in key order; an error from a Quota stops the batch there,
+// leaving the items before it written. The batch counts as one write for
+// MaxSetsPerSecond.
+func (p *cache) SetMulti(items map[string]interface{}, d time.Duration) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	if err := p.throttle(nil); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	p.mu.Lock()
+
+	now := time.Now()
+	writes := make([]batchWrite, len(keys))
+	var total int64
+	for i, k := range keys {
+		size, ok := p.measureItem(k, items[k], p.option.MaxItemSize)
+		if !ok || (p.option.MaxItemSize > 0 && size > p.option.MaxItemSize) {
+			p.mu.Unlock()
+			return ErrItemTooLarge
+		}
+
+		e := p.expiration(now, p.ttl(k, d))
+		writes[i] = batchWrite{
+			k:     k,
+			v:     items[k],
+			e:     e,
+			fresh: p.freshness(now, p.softTTL(k, 0), e),
+			size:  size,
+		}
+		total += size
+	}
+
+	// With room made for the batch, store finds nothing left to evict
+	err := p.makeRoom(keys, total)
+	for i := range writes {
+		if err != nil {
+			break
+		}
+
+		w := &writes[i]
+		w.previous, w.replaced, err = p.store(w.k, w.v, w.e, w.fresh, w.size)
+		w.stored = err == nil
+	}
+
+	hooks := p.writeHooks()
+	p.mu.Unlock()
+
+	p.fireEvictions(hooks.evictions)
+	for _, w := range writes {
+		if w.stored {
+			p.fireWriteHooks(hooks, w.k, w.v, w.previous, w.replaced)
+		}
+	}
+
+	return err
+}
+
+// makeRoom is evict for a batch of writes to keys, size bytes in total. The
+// items they replace are detached meanwhile, as store does for one write.
+func (p *cache) makeRoom(keys []string, size int64) error {
+	detached := make(map[string]*Item)
+	for _, k := range keys {
+		if old, exists := p.items[k]; exists {
+			p.detach(k, old)
+			detached[k] = old
+		}
+	}
+
+	err := p.evict(len(keys), size, detached)
+	for _, k := range keys {
+		if old, found := detached[k]; found {
+			p.reattach(k, old)
+		}
+	}
+
+	return err
+}
+
+// DeleteMulti removes keys under one lock acquisition, like Delete does for
+// each. OnEvicted is called for the removed items once the lock is
+// released, in the order of keys, as DeleteExpired does, and OnEvictedBatch
+// once for them all.
+func (p *cache) DeleteMulti(keys []string) {
+	p.mu.Lock()
+	var deleted []keyAndValue
+	for _, k := range keys {
+		if v, evicted := p.deleteKey(k); evicted {
+			deleted = append(deleted, keyAndValue{k, v})
+		}
+	}
+	onEvicted := p.onEvicted
+	evictions := p.takeEvictions()
+	p.mu.Unlock()
+
+	p.fireEvictions(evictions)
+	for _, kv := range deleted {
+		p.callOnEvicted(onEvicted, kv.key, kv.value)
+	}
+}
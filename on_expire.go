@@ -0,0 +1,31 @@
+package cache
+
+// ScheduleOnExpire attaches fn to the current item of k, to run once in its
+// own goroutine when the item is cleaned up as expired by the janitor or
+// DeleteExpired. fn is dropped if the item is deleted, evicted or written
+// again (including by Patch) first. Returns false if k has no unexpired item or the item
+// never expires.
+func (p *cache) ScheduleOnExpire(k string, fn func()) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	item, found := p.getItem(k)
+	if !found || item.Expiration == 0 {
+		return false
+	}
+
+	if p.expireHooks == nil {
+		p.expireHooks = make(map[string][]func())
+	}
+	p.expireHooks[k] = append(p.expireHooks[k], fn)
+
+	return true
+}
+
+// runExpireHooks starts the functions scheduled on k as it expires
+func (p *cache) runExpireHooks(k string) {
+	for _, fn := range p.expireHooks[k] {
+		go p.safely(fn)
+	}
+	delete(p.expireHooks, k)
+}
@@ -2,10 +2,156 @@ package cache
 
 import "time"
 
+// Unlimited as Option.MemoryLimit turns memory limiting off: item sizes are
+// not computed at all, Alloc stays at 0 and only TTLs and Capacity apply.
+// Quota needs sizes and has no effect with it.
+const Unlimited int64 = -1
+
+// defaultUnsizedItemSize is used when Option.UnsizedItemSize is zero
+const defaultUnsizedItemSize int64 = 64
+
 type Option struct {
+	// KeyManagerType is "queue" (the default), "decay" or "none". With
+	// "none" there is no eviction order, so writes over Capacity or
+	// MemoryLimit fail with ErrCacheFull as with RejectNew, unless
+	// FullPolicy is EvictExpiredOnly.
 	KeyManagerType    string
 	Capacity          int
 	MemoryLimit       int64
 	CleanupInterval   time.Duration
 	DefaultExpiration time.Duration
+
+	// ExpectedItems pre-sizes the items map and the key manager so a large
+	// cache doesn't rehash over and over while warming up
+	ExpectedItems int
+
+	// ShrinkRatio lets the janitor rebuild the items map once the live items
+	// fall below this fraction of the peak, since Go maps never give back
+	// their buckets. Zero disables it.
+	ShrinkRatio float64
+
+	// TrackLatency records Get, Set and eviction pass latencies into the
+	// histograms reported by Stats
+	TrackLatency bool
+
+	// FullPolicy decides what a write does when Capacity or MemoryLimit is reached
+	FullPolicy FullPolicy
+
+	// MaxItemAge caps how long any item lives after it was written, including
+	// items set with NoExpiration or a longer TTL. Zero disables it.
+	MaxItemAge time.Duration
+
+	// Quota limits the memory used under key prefixes, enforced on writes
+	Quota Quota
+
+	// FairEviction spreads memory pressure evictions across Quota prefixes:
+	// the victim is the oldest key of the prefix using the largest share of
+	// its quota, instead of the oldest key overall
+	FairEviction bool
+
+	// IntegrityCheck makes the janitor verify the memory usage, item count and
+	// key manager against the items on every run and repair any drift
+	IntegrityCheck bool
+
+	// PanicHandler receives panics recovered from OnEvicted, OnReplaced and
+	// OnSet callbacks. When nil, a panicking callback panics the caller.
+	PanicHandler func(recovered interface{})
+
+	// Tiebreak orders eviction candidates that are otherwise equal: items
+	// expiring at the same time and quota prefixes with the same share under
+	// FairEviction. It reports whether a goes first; the default is key order.
+	Tiebreak func(a, b string) bool
+
+	// TombstoneTTL keeps a record of keys removed by Delete or GetAndDelete
+	// for this long, see Tombstones. Zero disables tombstones.
+	TombstoneTTL time.Duration
+
+	// LeaseTTL is how long a lease granted by LeaseGet stays valid, 10 seconds
+	// when zero
+	LeaseTTL time.Duration
+
+	// UnsizedItemSize is the flat size SetUnsized charges for a value,
+	// 64 bytes when zero
+	UnsizedItemSize int64
+
+	// MaxItemSize rejects writes of items bigger than this many bytes with
+	// ErrItemTooLarge. Measuring stops as soon as an item is known to be too
+	// big, however deep in the value. Zero disables it.
+	MaxItemSize int64
+
+	// EventBufferSize keeps this many of the most recent sets, deletes,
+	// expirations and evictions for RecentEvents. Zero disables it.
+	EventBufferSize int
+
+	// EvictionTraceSize keeps this many of the most recent eviction
+	// decisions, with the cache state behind each, for EvictionTrace.
+	// Zero disables it.
+	EvictionTraceSize int
+
+	// EvictionBudget bounds the time a DeleteExpired pass or the eviction
+	// for one write spends, e.g. 2ms. Expired items left over are deleted on
+	// the next pass and a write that couldn't make room fails with
+	// ErrCacheFull. Zero means no bound.
+	EvictionBudget time.Duration
+
+	// TimeResolution makes reads check expirations against a timestamp
+	// refreshed at this interval instead of calling time.Now, so items may
+	// be served up to TimeResolution past their expiration. Zero uses the
+	// exact time.
+	TimeResolution time.Duration
+
+	// MaxSetsPerSecond limits writes with a token bucket holding a second's
+	// worth of them. Writes over the limit fail with ErrRateLimited, or wait
+	// for their turn with BlockOnRateLimit. Zero disables it.
+	MaxSetsPerSecond int
+	BlockOnRateLimit bool
+
+	// SlowOnEvicted is how long an OnEvicted call may take before it is
+	// counted in Stats.SlowOnEvicted. With AsyncOnEvicted, the first slow
+	// call makes every later one run in its own goroutine, so callbacks
+	// stop holding up Delete and cleanup. Zero disables it.
+	SlowOnEvicted  time.Duration
+	AsyncOnEvicted bool
+
+	// DecayHalfLife is how fast access scores fade with the "decay"
+	// KeyManagerType, keymanager.DefaultHalfLife when zero
+	DecayHalfLife time.Duration
+
+	// TTLRules override the TTL of writes under their prefixes. They can be
+	// replaced at runtime with SetTTLRules.
+	TTLRules TTLRules
+
+	// SoftTTLRules give the writes under their prefixes a freshness, as
+	// SetWithFreshness does: past it GetWithFreshness reports them stale,
+	// while TTLRules, the TTL of the write and MaxItemAge still decide when
+	// they are removed. A freshness passed to SetWithFreshness wins. They
+	// can be replaced at runtime with SetSoftTTLRules.
+	SoftTTLRules TTLRules
+
+	// KeyManagerCapacity caps how many keys the key manager tracks, for key
+	// managers that take a size like the queue, to bound the memory spent
+	// on ordering keys. Past it, the key manager's next victim is untracked
+	// to make room; nothing is kept for untracked keys. They are evicted
+	// first, the oldest written of a few sampled from the items, so their
+	// order is approximate. Zero tracks every key.
+	KeyManagerCapacity int
+
+	// DeterministicSeed, when non-zero, seeds the cache's randomness, e.g.
+	// the jitter of ScheduleRefresh, so that a run can be reproduced. The
+	// keys sampled for eviction past KeyManagerCapacity are then drawn from
+	// the seed too, at the cost of listing the untracked keys per eviction.
+	DeterministicSeed int64
 }
+
+// FullPolicy is the behavior of a write that doesn't fit in the cache
+type FullPolicy int
+
+const (
+	// EvictOldest removes keys in the key manager's order until the item fits
+	EvictOldest FullPolicy = iota
+	// RejectNew leaves the cache untouched and fails the write with ErrCacheFull
+	RejectNew
+	// EvictExpiredOnly removes expired items and fails the write with
+	// ErrCacheFull if that isn't enough
+	EvictExpiredOnly
+)
@@ -0,0 +1,59 @@
+package cache
+
+import "fmt"
+
+// PatchFunc receives the current value of an item and returns the value to
+// store, which may be the same one mutated in place, and how many bytes
+// the change added to it (negative when it shrank).
+type PatchFunc func(current interface{}) (updated interface{}, sizeDelta int64, err error)
+
+// Patch updates an existing, unexpired item under the write lock without
+// measuring it again: its size is adjusted by the delta apply reports, and
+// its expiration is kept. MemoryLimit, MaxItemSize and Quota apply to the
+// new size, and OnReplaced and OnSet fire as for Replace. An error from
+// apply leaves the item as it was, as far as the cache is concerned.
+func (p *cache) Patch(k string, apply PatchFunc) error {
+	if err := p.throttle(nil); err != nil {
+		return err
+	}
+
+	v, previous, replaced, hooks, err := p.patch(k, apply)
+	p.fireEvictions(hooks.evictions)
+	if err != nil {
+		return err
+	}
+
+	p.fireWriteHooks(hooks, k, v, previous, replaced)
+
+	return nil
+}
+
+// patch is the locked part of Patch. The lock is released on return even if
+// apply panics.
+func (p *cache) patch(k string, apply PatchFunc) (v, previous interface{}, replaced bool, hooks writeHooks, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	item, found := p.getItem(k)
+	if !found {
+		return nil, nil, false, hooks, fmt.Errorf("Item %s doesn't exist", k)
+	}
+
+	v, delta, err := apply(item.Object)
+	if err != nil {
+		return nil, nil, false, hooks, err
+	}
+
+	// Without a memory limit sizes aren't tracked, as in flatItemSize
+	size := item.Mem + delta
+	if size < 0 || p.option.MemoryLimit == Unlimited {
+		size = 0
+	}
+
+	if p.option.MaxItemSize > 0 && size > p.option.MaxItemSize {
+		return nil, nil, false, hooks, ErrItemTooLarge
+	}
+
+	previous, replaced, err = p.store(k, v, item.Expiration, item.Fresh, size)
+	return v, previous, replaced, p.writeHooks(), err
+}
@@ -0,0 +1,34 @@
+package cache
+
+import "sync"
+
+// itemPool recycles Item structs between Set and Delete so heavy churn
+// doesn't keep handing the GC fresh allocations.
+// Counters are guarded by the cache's write lock, same as the items map.
+type itemPool struct {
+	pool     sync.Pool
+	allocs   int64
+	reuses   int64
+	releases int64
+}
+
+func (p *itemPool) get() *Item {
+	if item, ok := p.pool.Get().(*Item); ok {
+		p.reuses++
+		return item
+	}
+
+	p.allocs++
+	return &Item{}
+}
+
+func (p *itemPool) put(item *Item) {
+	if item == nil {
+		return
+	}
+
+	// Drop the reference so a pooled Item doesn't keep the value alive
+	*item = Item{}
+	p.releases++
+	p.pool.Put(item)
+}
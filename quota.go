@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"errors"
+	"strings"
+)
+
+// Quota limits the memory, in bytes, used by the keys under each prefix,
+// e.g. Quota{"tenant:a:": 10 << 20}. A key is charged to the longest prefix
+// it matches; keys matching no prefix only count against MemoryLimit.
+type Quota map[string]int64
+
+// ErrQuotaExceeded is returned by writes that would take a prefix over its Quota
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// prefix returns the longest prefix of the quota that k falls under
+func (q Quota) prefix(k string) (string, bool) {
+	return longestPrefix(q, k)
+}
+
+// longestPrefix returns the longest key of m that k starts with
+func longestPrefix[V any](m map[string]V, k string) (string, bool) {
+	var (
+		match string
+		found bool
+	)
+
+	for prefix := range m {
+		if strings.HasPrefix(k, prefix) && (!found || len(prefix) > len(match)) {
+			match, found = prefix, true
+		}
+	}
+
+	return match, found
+}
+
+// checkQuota fails if growing k's prefix by delta bytes goes over its quota
+func (p *cache) checkQuota(k string, delta int64) error {
+	prefix, found := p.option.Quota.prefix(k)
+	if !found {
+		return nil
+	}
+
+	if p.quotaUsage[prefix]+delta > p.option.Quota[prefix] {
+		return ErrQuotaExceeded
+	}
+
+	return nil
+}
+
+func (p *cache) addQuotaUsage(k string, delta int64) {
+	prefix, found := p.option.Quota.prefix(k)
+	if !found {
+		return
+	}
+
+	if p.quotaUsage == nil {
+		p.quotaUsage = make(map[string]int64, len(p.option.Quota))
+	}
+	p.quotaUsage[prefix] += delta
+}
+
+// keyLister is implemented by key managers that can list their keys in
+// eviction order, like the queue
+type keyLister interface {
+	GetValues() []string
+}
+
+// memoryVictim returns the next key to evict under memory pressure and
+// whether FairEviction picked it
+func (p *cache) memoryVictim() (string, bool, error) {
+	if p.option.FairEviction {
+		if key, found := p.fairVictim(); found {
+			return key, true, nil
+		}
+	}
+
+	key, err := p.nextVictim()
+	return key, false, err
+}
+
+// fairVictim returns the oldest key of the group using the largest share of
+// its budget. Each Quota prefix is a group measured against its quota, and
+// keys under no prefix are a group measured against MemoryLimit. Prefixes
+// with equal shares are ordered by Option.Tiebreak and come before the
+// unprefixed group.
+// It walks the whole key manager order, so it's only used with FairEviction.
+func (p *cache) fairVictim() (string, bool) {
+	lister, ok := p.keyManager.(keyLister)
+	if !ok || len(p.option.Quota) == 0 {
+		return "", false
+	}
+
+	var (
+		heaviest   string
+		prefixed   bool
+		share      float64
+		unprefixed = p.memUsage
+	)
+
+	for prefix, usage := range p.quotaUsage {
+		unprefixed -= usage
+		if usage <= 0 {
+			continue
+		}
+
+		s := float64(usage) / float64(p.option.Quota[prefix])
+		if s > share || (s == share && prefixed && p.tiebreak(prefix, heaviest)) {
+			heaviest, prefixed, share = prefix, true, s
+		}
+	}
+
+	if p.option.MemoryLimit > 0 && unprefixed > 0 {
+		if s := float64(unprefixed) / float64(p.option.MemoryLimit); s > share {
+			heaviest, prefixed, share = "", false, s
+		}
+	}
+
+	if share == 0 {
+		return "", false
+	}
+
+	for _, k := range lister.GetValues() {
+		if _, found := p.items[k]; !found {
+			continue
+		}
+
+		prefix, found := p.option.Quota.prefix(k)
+		if found == prefixed && prefix == heaviest {
+			return k, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned by writes over Option.MaxSetsPerSecond when
+// Option.BlockOnRateLimit isn't set
+var ErrRateLimited = errors.New("write rate limit exceeded")
+
+// tokenBucket holds up to a second's worth of writes and refills at rate
+// tokens per second
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+}
+
+// allow takes a token if one is available
+func (b *tokenBucket) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+
+	return true
+}
+
+// reserve takes a token, going into debt if needed, and returns how long to
+// wait before using it
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// throttle applies Option.MaxSetsPerSecond to a write. Blocking writes wait
+// for their token unless ctx is done first.
+func (p *cache) throttle(ctx context.Context) error {
+	if p.rateLimit == nil {
+		return nil
+	}
+
+	if !p.option.BlockOnRateLimit {
+		if !p.rateLimit.allow() {
+			return ErrRateLimited
+		}
+
+		return nil
+	}
+
+	wait := p.rateLimit.reserve()
+	if wait <= 0 {
+		return nil
+	}
+
+	if ctx == nil {
+		time.Sleep(wait)
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ErrRateLimited
+	}
+}
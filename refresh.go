@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// RefreshFunc computes the value of k for ScheduleRefresh
+type RefreshFunc func(k string) (interface{}, error)
+
+// refreshJitter spreads refreshes by up to this share of their period, so
+// keys scheduled together don't all recompute on the same tick
+const refreshJitter = 0.1
+
+type refresh struct {
+	every time.Duration
+	next  int64
+	fn    RefreshFunc
+}
+
+// ScheduleRefresh has the janitor recompute k with fn about every period,
+// give or take 10%, starting with its next tick, replacing any schedule k
+// had. Values are stored like Set with DefaultExpiration; when fn fails the
+// current value is kept. Refreshes run on janitor ticks, so they need a
+// CleanupInterval and are no more precise than it.
+func (p *cache) ScheduleRefresh(k string, every time.Duration, fn RefreshFunc) error {
+	if p.janitor == nil {
+		return errors.New("refreshes need a janitor, set CleanupInterval")
+	}
+
+	if every <= 0 {
+		return errors.New("refresh period must be positive")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.refreshes == nil {
+		p.refreshes = make(map[string]*refresh)
+	}
+	p.refreshes[k] = &refresh{
+		every: every,
+		next:  time.Now().UnixNano(),
+		fn:    fn,
+	}
+
+	return nil
+}
+
+// CancelRefresh stops the refreshes of k, leaving its current value
+func (p *cache) CancelRefresh(k string) {
+	p.mu.Lock()
+	delete(p.refreshes, k)
+	p.mu.Unlock()
+}
+
+// runRefreshes recomputes the keys whose refresh is due, outside the lock
+func (p *cache) runRefreshes() {
+	now := time.Now()
+
+	p.mu.Lock()
+	// Keys are taken in order so a seeded cache draws the same jitters
+	keys := make([]string, 0, len(p.refreshes))
+	for k := range p.refreshes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var due []string
+	fns := make(map[string]RefreshFunc)
+	for _, k := range keys {
+		r := p.refreshes[k]
+		if r.next > now.UnixNano() {
+			continue
+		}
+
+		due = append(due, k)
+		fns[k] = r.fn
+		jitter := (p.random().Float64()*2 - 1) * refreshJitter * float64(r.every)
+		r.next = now.Add(r.every + time.Duration(jitter)).UnixNano()
+	}
+	p.mu.Unlock()
+
+	for _, k := range due {
+		k, fn := k, fns[k]
+		p.safely(func() {
+			if v, err := fn(k); err == nil {
+				p.Set(k, v, DefaultExpiration)
+			}
+		})
+	}
+}
+
+// random is the cache's source of randomness, used under the write lock
+func (p *cache) random() *rand.Rand {
+	if p.rng == nil {
+		p.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	return p.rng
+}
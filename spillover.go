@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"sort"
+
+	keymanager "github.com/manhcuongincusar1/pointer-cache/key_manager"
+)
+
+// With Option.KeyManagerCapacity the key manager only tracks that many keys.
+// Adding one more untracks its next victim to make room. Nothing is kept for
+// untracked keys: they are the items the key manager doesn't hold. Eviction
+// takes them first, the oldest written of a few sampled from the items, then
+// follows the key manager.
+
+// untrackedSample is how many untracked keys eviction compares. Finding
+// them skips at most KeyManagerCapacity tracked items.
+const untrackedSample = 5
+
+// keyContainer is implemented by key managers that can tell whether they
+// track a key, like the queue
+type keyContainer interface {
+	Contains(key string) bool
+}
+
+// limitsKeys reports whether the key manager may leave keys untracked
+func (p *cache) limitsKeys() bool {
+	return p.option.KeyManagerCapacity > 0
+}
+
+// trackKey hands a new key to the key manager, untracking its next victim
+// if it is full
+func (p *cache) trackKey(k string) {
+	if p.keyManager.Add(k) || !p.limitsKeys() {
+		return
+	}
+
+	if victim, err := p.keyManager.Peek(); err == nil {
+		p.keyManager.Delete(victim)
+	}
+	p.keyManager.Add(k)
+}
+
+// touchKey moves k to the back of the key manager, tracking it again if
+// it was untracked
+func (p *cache) touchKey(k string) {
+	if toucher, ok := p.keyManager.(keymanager.Toucher); ok && !p.limitsKeys() {
+		toucher.Touch(k)
+		return
+	}
+
+	p.keyManager.Delete(k)
+	p.trackKey(k)
+}
+
+// nextVictim is the next key to evict for Capacity
+func (p *cache) nextVictim() (string, error) {
+	if key, found := p.untrackedVictim(); found {
+		return key, nil
+	}
+
+	return p.keyManager.Peek()
+}
+
+// untrackedVictim returns the oldest written of untrackedSample untracked
+// keys: the first met in the items, or drawn with the seeded randomness
+// under DeterministicSeed
+func (p *cache) untrackedVictim() (string, bool) {
+	container, ok := p.keyManager.(keyContainer)
+	if !ok || !p.limitsKeys() || len(p.items) <= p.keyManager.Size() {
+		return "", false
+	}
+
+	var sample []string
+	if p.option.DeterministicSeed != 0 {
+		sample = p.drawUntracked(container)
+	} else {
+		for k := range p.items {
+			if container.Contains(k) {
+				continue
+			}
+
+			sample = append(sample, k)
+			if len(sample) == untrackedSample {
+				break
+			}
+		}
+	}
+
+	var victim string
+	for _, k := range sample {
+		if victim == "" || p.writtenBefore(k, victim) {
+			victim = k
+		}
+	}
+
+	return victim, victim != ""
+}
+
+// drawUntracked draws untrackedSample untracked keys, in key order so that
+// the draw only depends on the seed. It lists every untracked key, so it
+// costs a scan of the items per eviction.
+func (p *cache) drawUntracked(container keyContainer) []string {
+	var keys []string
+	for k := range p.items {
+		if !container.Contains(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	if len(keys) <= untrackedSample {
+		return keys
+	}
+
+	sample := make([]string, untrackedSample)
+	for i := range sample {
+		sample[i] = keys[p.random().Intn(len(keys))]
+	}
+
+	return sample
+}
+
+// writtenBefore reports whether a was written before b, ties broken by
+// Option.Tiebreak
+func (p *cache) writtenBefore(a, b string) bool {
+	x, y := p.items[a].Updated, p.items[b].Updated
+	if x != y {
+		return x < y
+	}
+
+	return p.tiebreak(a, b)
+}
+
+// untrackedKeys lists every untracked key, oldest written first. Eviction
+// only samples them, so this is the order it tends to, for predictions.
+func (p *cache) untrackedKeys() []string {
+	container, ok := p.keyManager.(keyContainer)
+	if !ok || !p.limitsKeys() {
+		return nil
+	}
+
+	var keys []string
+	for k := range p.items {
+		if !container.Contains(k) {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return p.writtenBefore(keys[i], keys[j])
+	})
+
+	return keys
+}
@@ -0,0 +1,180 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a point in time view of the cache counters
+type Stats struct {
+	Items int
+	Alloc int64
+
+	// Item pool: Allocs counts Items created because the pool was empty,
+	// Reuses counts Items taken back out of the pool and Releases counts
+	// Items returned to it after a delete or overwrite.
+	ItemPoolAllocs   int64
+	ItemPoolReuses   int64
+	ItemPoolReleases int64
+
+	// Latencies, only filled when Option.TrackLatency is set
+	GetLatency      LatencyHistogram
+	SetLatency      LatencyHistogram
+	EvictionLatency LatencyHistogram
+
+	// QuotaUsage is the memory used under each Quota prefix
+	QuotaUsage map[string]int64
+
+	// QuotaHits counts the lookups under each Quota prefix, so tenants
+	// sharing a cache can see how effective their part of it is
+	QuotaHits map[string]HitStats
+
+	// Labels counts the traffic of each label given to WithLabel
+	Labels map[string]LabelStats
+
+	// Janitor is the health of the cleanup goroutine, zero without one
+	Janitor JanitorStats
+
+	// SlowOnEvicted counts OnEvicted calls over Option.SlowOnEvicted, and
+	// OnEvictedAsync tells whether they have been switched to goroutines
+	SlowOnEvicted  int64
+	OnEvictedAsync bool
+}
+
+// JanitorStats tells whether the janitor keeps up: a LastStart after
+// LastEnd that stays there means a pass is stuck, and Running false means
+// the goroutine is gone
+type JanitorStats struct {
+	Running   bool
+	Passes    int64
+	LastStart time.Time
+	LastEnd   time.Time
+	// LastRemoved is how many expired items the last pass removed
+	LastRemoved int
+}
+
+func (p *janitor) stats() JanitorStats {
+	stats := JanitorStats{
+		Running:     atomic.LoadInt32(&p.running) == 1,
+		Passes:      atomic.LoadInt64(&p.passes),
+		LastRemoved: int(atomic.LoadInt64(&p.lastRemoved)),
+	}
+
+	if start := atomic.LoadInt64(&p.lastStart); start > 0 {
+		stats.LastStart = time.Unix(0, start)
+	}
+	if end := atomic.LoadInt64(&p.lastEnd); end > 0 {
+		stats.LastEnd = time.Unix(0, end)
+	}
+
+	return stats
+}
+
+// HitStats counts lookups that found an unexpired item and lookups that didn't
+type HitStats struct {
+	Hits   int64
+	Misses int64
+}
+
+// Ratio is the share of lookups that were hits, 0 without lookups
+func (s HitStats) Ratio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// hitCounters are the atomic counters behind HitStats
+type hitCounters struct {
+	hits   int64
+	misses int64
+}
+
+// countLookup counts a lookup of k against its Quota prefix and the
+// handle's label. The counters are created with the cache or the handle, so
+// this only needs the read lock.
+func (p *cache) countLookup(k string, hit bool) {
+	p.countLabelLookup(hit)
+
+	if len(p.quotaHits) == 0 {
+		return
+	}
+
+	prefix, found := p.option.Quota.prefix(k)
+	if !found {
+		return
+	}
+
+	if hit {
+		atomic.AddInt64(&p.quotaHits[prefix].hits, 1)
+	} else {
+		atomic.AddInt64(&p.quotaHits[prefix].misses, 1)
+	}
+}
+
+// Stats returns the current counters of the cache
+func (p *cache) Stats() Stats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	quotaUsage := make(map[string]int64, len(p.quotaUsage))
+	for prefix, usage := range p.quotaUsage {
+		quotaUsage[prefix] = usage
+	}
+
+	quotaHits := make(map[string]HitStats, len(p.quotaHits))
+	for prefix, counters := range p.quotaHits {
+		quotaHits[prefix] = HitStats{
+			Hits:   atomic.LoadInt64(&counters.hits),
+			Misses: atomic.LoadInt64(&counters.misses),
+		}
+	}
+
+	labels := make(map[string]LabelStats, len(p.labels))
+	for label, counters := range p.labels {
+		labels[label] = LabelStats{
+			HitStats: HitStats{
+				Hits:   atomic.LoadInt64(&counters.hits),
+				Misses: atomic.LoadInt64(&counters.misses),
+			},
+			Sets: atomic.LoadInt64(&counters.sets),
+		}
+	}
+
+	var janitor JanitorStats
+	if p.janitor != nil {
+		janitor = p.janitor.stats()
+	}
+
+	return Stats{
+		Items:            len(p.items),
+		Alloc:            p.memUsage,
+		ItemPoolAllocs:   p.itemPool.allocs,
+		ItemPoolReuses:   p.itemPool.reuses,
+		ItemPoolReleases: p.itemPool.releases,
+		GetLatency:       p.latency.get.snapshot(),
+		SetLatency:       p.latency.set.snapshot(),
+		EvictionLatency:  p.latency.eviction.snapshot(),
+		QuotaUsage:       quotaUsage,
+		QuotaHits:        quotaHits,
+		Labels:           labels,
+		Janitor:          janitor,
+		SlowOnEvicted:    atomic.LoadInt64(&p.slowEvicted),
+		OnEvictedAsync:   atomic.LoadInt32(&p.evictedAsync) == 1,
+	}
+}
+
+// latencies groups the per-operation histograms of a cache
+type latencies struct {
+	get      latencyHistogram
+	set      latencyHistogram
+	eviction latencyHistogram
+}
+
+// track records the time elapsed since start into h.
+// Callers defer it behind an Option.TrackLatency check so the hot path
+// doesn't pay for time.Now() when tracking is off.
+func (p *cache) track(h *latencyHistogram, start time.Time) {
+	h.observe(time.Since(start))
+}
@@ -0,0 +1,43 @@
+package stringcache
+
+import (
+	"time"
+
+	cache "github.com/manhcuongincusar1/pointer-cache"
+)
+
+// StringCache stores string values. Sizes are charged as the length of the
+// value, next to the key the cache accounts for itself, so writes never walk
+// the value to measure it.
+type StringCache struct {
+	cache *cache.Cache
+}
+
+// New creates a StringCache on top of c. c should only hold strings written
+// through the StringCache.
+func New(c *cache.Cache) *StringCache {
+	return &StringCache{
+		cache: c,
+	}
+}
+
+// Get returns the value of k and whether an unexpired value was found
+func (p *StringCache) Get(k string) (string, bool) {
+	v, found := p.cache.Get(k)
+	if !found {
+		return "", false
+	}
+
+	s, ok := v.(string)
+	return s, ok
+}
+
+// Set stores v under k for d, see cache.Set
+func (p *StringCache) Set(k, v string, d time.Duration) error {
+	return p.cache.SetWithSize(k, v, int64(len(v)), d)
+}
+
+// Delete removes k
+func (p *StringCache) Delete(k string) {
+	p.cache.Delete(k)
+}
@@ -0,0 +1,36 @@
+package stringcache
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	cache "github.com/manhcuongincusar1/pointer-cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStringCache(t *testing.T) {
+	c, err := cache.New(&cache.Option{MemoryLimit: 222222}, nil)
+	assert.Nil(t, err)
+
+	sc := New(c)
+	assert.Nil(t, sc.Set("greeting", "hello", cache.NoExpiration))
+
+	v, found := sc.Get("greeting")
+	assert.True(t, found)
+	assert.Equal(t, "hello", v)
+
+	// Sizes follow the length of the value
+	alloc := c.Alloc()
+	assert.Nil(t, sc.Set("greeting", strings.Repeat("x", 105), cache.NoExpiration))
+	assert.Equal(t, alloc+100, c.Alloc())
+
+	assert.Nil(t, sc.Set("short", "x", time.Millisecond))
+	time.Sleep(2 * time.Millisecond)
+	_, found = sc.Get("short")
+	assert.False(t, found)
+
+	sc.Delete("greeting")
+	_, found = sc.Get("greeting")
+	assert.False(t, found)
+}
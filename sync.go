@@ -0,0 +1,120 @@
+package cache
+
+import "time"
+
+// Digest describes one entry of a cache for anti-entropy sync
+type Digest struct {
+	Version    int64 // Item.Updated of the entry
+	Expiration int64
+}
+
+// CachePeer is the other side of SyncWith. *Cache implements it, so two
+// in-process caches can sync directly; remote replicas can wrap a transport.
+type CachePeer interface {
+	// Digests returns a digest for each unexpired entry
+	Digests() map[string]Digest
+	// Fetch returns copies of the unexpired entries for the given keys
+	Fetch(keys []string) map[string]Item
+}
+
+// Digests returns a digest for each unexpired item
+func (p *cache) Digests() map[string]Digest {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	now := time.Now().UnixNano()
+	digests := make(map[string]Digest, len(p.items))
+	for k, item := range p.items {
+		if item.Expiration > 0 && now > item.Expiration {
+			continue
+		}
+
+		digests[k] = Digest{
+			Version:    item.Updated,
+			Expiration: item.Expiration,
+		}
+	}
+
+	return digests
+}
+
+// Fetch returns copies of the unexpired items for the given keys
+func (p *cache) Fetch(keys []string) map[string]Item {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	items := make(map[string]Item, len(keys))
+	for _, k := range keys {
+		if item, found := p.getItem(k); found {
+			items[k] = *item
+		}
+	}
+
+	return items
+}
+
+// SyncWith pulls from peer every entry that is missing here or newer there,
+// keeping the peer's version, expiration and size, and returns how many entries
+// were copied. Keys deleted here after the peer's version (see
+// Option.TombstoneTTL) are not brought back. Syncing both ways makes two
+// caches converge.
+func (p *cache) SyncWith(peer CachePeer) (int, error) {
+	local := p.Digests()
+
+	var keys []string
+	for k, digest := range peer.Digests() {
+		if current, found := local[k]; !found || digest.Version > current.Version {
+			keys = append(keys, k)
+		}
+	}
+
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	items := peer.Fetch(keys)
+
+	p.mu.Lock()
+	copied, err := p.copyItems(keys, items)
+	evictions := p.takeEvictions()
+	p.mu.Unlock()
+
+	p.fireEvictions(evictions)
+
+	return copied, err
+}
+
+// copyItems is the locked part of SyncWith, storing the fetched items that
+// are newer than the local ones
+func (p *cache) copyItems(keys []string, items map[string]Item) (int, error) {
+	copied := 0
+	for _, k := range keys {
+		item, found := items[k]
+		if !found || item.Expired() {
+			continue
+		}
+
+		// The entry may have been written or deleted here since the digests
+		if current, found := p.items[k]; found && current.Updated >= item.Updated {
+			continue
+		}
+		if deletedAt, found := p.tombstones[k]; found && deletedAt >= item.Updated {
+			continue
+		}
+
+		// The peer's sizes follow its own limits, the item is charged here
+		// as a local write would be
+		size, ok := p.measureItem(k, item.Object, p.option.MaxItemSize)
+		if !ok || (p.option.MaxItemSize > 0 && size > p.option.MaxItemSize) {
+			return copied, ErrItemTooLarge
+		}
+
+		if _, _, err := p.store(k, item.Object, item.Expiration, item.Fresh, size); err != nil {
+			return copied, err
+		}
+		p.items[k].Updated = item.Updated
+		copied++
+	}
+
+	return copied, nil
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrLockTimeout is returned by the WithTimeout variants when the context
+// is done before the cache lock could be taken
+var ErrLockTimeout = errors.New("timed out waiting for the cache lock")
+
+// maxLockBackoff caps the wait between two attempts at the lock
+const maxLockBackoff = time.Millisecond
+
+// lockContext takes the write lock, giving up with ErrLockTimeout once ctx
+// is done. A nil ctx blocks like Lock.
+func (p *cache) lockContext(ctx context.Context) error {
+	if ctx == nil {
+		p.mu.Lock()
+		return nil
+	}
+
+	backoff := time.Microsecond
+	for !p.mu.TryLock() {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ErrLockTimeout
+		case <-timer.C:
+		}
+
+		if backoff *= 2; backoff > maxLockBackoff {
+			backoff = maxLockBackoff
+		}
+	}
+
+	// Don't write once the caller has given up
+	if ctx.Err() != nil {
+		p.mu.Unlock()
+		return ErrLockTimeout
+	}
+
+	return nil
+}
+
+// SetWithTimeout is Set failing fast with ErrLockTimeout when ctx is done
+// before the write lock is free, e.g. while a long eviction pass holds it.
+// The lock is polled, so it doesn't queue behind other writers.
+func (p *cache) SetWithTimeout(ctx context.Context, k string, v interface{}, d time.Duration) error {
+	if p.option.TrackLatency {
+		defer p.track(&p.latency.set, time.Now())
+	}
+
+	_, _, err := p.write(k, v, d, nil, &writeOptions{ctx: ctx})
+	return err
+}
@@ -0,0 +1,97 @@
+package tokencache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	cache "github.com/manhcuongincusar1/pointer-cache"
+)
+
+// ErrInvalidToken is the error an Introspect func wraps to reject a token.
+// Only rejections are cached negatively, other errors (e.g. the issuer being
+// unreachable) are returned without being remembered.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Introspect validates a token with its issuer and returns its claims and
+// expiry (the exp claim). A zero expiry means the token doesn't expire.
+type Introspect func(token string) (claims interface{}, expiresAt time.Time, err error)
+
+type Option struct {
+	// TTL is the longest a valid result is kept; it is further capped by the
+	// token's expiry. Zero means the expiry alone decides.
+	TTL time.Duration
+	// NegativeTTL is how long a rejected token is remembered. Zero disables
+	// negative caching.
+	NegativeTTL time.Duration
+}
+
+// TokenCache caches introspection results keyed by a hash of the token, so
+// raw tokens are never held as cache keys
+type TokenCache struct {
+	cache      *cache.Cache
+	introspect Introspect
+	option     Option
+}
+
+func New(c *cache.Cache, introspect Introspect, option Option) *TokenCache {
+	return &TokenCache{
+		cache:      c,
+		introspect: introspect,
+		option:     option,
+	}
+}
+
+type result struct {
+	claims interface{}
+	err    error
+}
+
+// Validate returns the claims of a valid token, introspecting it only when
+// no cached result exists
+func (p *TokenCache) Validate(token string) (interface{}, error) {
+	key := cacheKey(token)
+	if v, found := p.cache.Get(key); found {
+		r := v.(result)
+		return r.claims, r.err
+	}
+
+	claims, expiresAt, err := p.introspect(token)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) && p.option.NegativeTTL > 0 {
+			p.cache.Set(key, result{err: err}, p.option.NegativeTTL)
+		}
+
+		return nil, err
+	}
+
+	ttl := p.option.TTL
+	if !expiresAt.IsZero() {
+		until := time.Until(expiresAt)
+		if until <= 0 {
+			return nil, ErrInvalidToken
+		}
+
+		if ttl <= 0 || until < ttl {
+			ttl = until
+		}
+	}
+
+	if ttl <= 0 {
+		ttl = cache.NoExpiration
+	}
+	p.cache.Set(key, result{claims: claims}, ttl)
+
+	return claims, nil
+}
+
+// Forget drops the cached result of a token, e.g. after it was revoked
+func (p *TokenCache) Forget(token string) {
+	p.cache.Delete(cacheKey(token))
+}
+
+func cacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:])
+}
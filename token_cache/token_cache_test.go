@@ -0,0 +1,75 @@
+package tokencache
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	cache "github.com/manhcuongincusar1/pointer-cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	c, err := cache.New(&cache.Option{MemoryLimit: 222222}, nil)
+	assert.Nil(t, err)
+
+	calls := 0
+	tc := New(c, func(token string) (interface{}, time.Time, error) {
+		calls++
+		switch token {
+		case "good":
+			return "alice", time.Now().Add(time.Hour), nil
+		case "short":
+			return "bob", time.Now().Add(5 * time.Millisecond), nil
+		case "down":
+			return nil, time.Time{}, errors.New("issuer unreachable")
+		}
+
+		return nil, time.Time{}, fmt.Errorf("%w: unknown", ErrInvalidToken)
+	}, Option{
+		TTL:         time.Minute,
+		NegativeTTL: time.Minute,
+	})
+
+	t.Run("Valid token is introspected once", func(t *testing.T) {
+		calls = 0
+		for i := 0; i < 3; i++ {
+			claims, err := tc.Validate("good")
+			assert.Nil(t, err)
+			assert.Equal(t, "alice", claims)
+		}
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("TTL is capped by the expiry", func(t *testing.T) {
+		calls = 0
+		tc.Validate("short")
+		<-time.After(6 * time.Millisecond)
+		tc.Validate("short")
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("Rejections are cached", func(t *testing.T) {
+		calls = 0
+		_, err := tc.Validate("bad")
+		assert.True(t, errors.Is(err, ErrInvalidToken))
+		_, err = tc.Validate("bad")
+		assert.True(t, errors.Is(err, ErrInvalidToken))
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("Other errors are not cached", func(t *testing.T) {
+		calls = 0
+		tc.Validate("down")
+		tc.Validate("down")
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("Forget", func(t *testing.T) {
+		calls = 0
+		tc.Forget("good")
+		tc.Validate("good")
+		assert.Equal(t, 1, calls)
+	})
+}
@@ -0,0 +1,50 @@
+package cache
+
+import "time"
+
+// Tombstones returns the keys deleted within Option.TombstoneTTL with the
+// time they were deleted, so deletions can be passed on to replicas that
+// missed them. Writing a key again clears its tombstone.
+func (p *cache) Tombstones() map[string]time.Time {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	cutoff := time.Now().Add(-p.option.TombstoneTTL).UnixNano()
+	tombstones := make(map[string]time.Time, len(p.tombstones))
+	for k, deletedAt := range p.tombstones {
+		if deletedAt > cutoff {
+			tombstones[k] = time.Unix(0, deletedAt)
+		}
+	}
+
+	return tombstones
+}
+
+// bury records a tombstone for a key deleted by the caller
+func (p *cache) bury(k string) {
+	if p.option.TombstoneTTL <= 0 {
+		return
+	}
+
+	if p.tombstones == nil {
+		p.tombstones = make(map[string]int64)
+	}
+	p.tombstones[k] = time.Now().UnixNano()
+}
+
+// purgeTombstones drops tombstones older than Option.TombstoneTTL
+func (p *cache) purgeTombstones() {
+	if p.option.TombstoneTTL <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cutoff := time.Now().Add(-p.option.TombstoneTTL).UnixNano()
+	for k, deletedAt := range p.tombstones {
+		if deletedAt <= cutoff {
+			delete(p.tombstones, k)
+		}
+	}
+}
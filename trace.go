@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"time"
+
+	keymanager "github.com/manhcuongincusar1/pointer-cache/key_manager"
+)
+
+// EvictionDecision is an entry of the eviction trace: one item removed by
+// eviction or expiration, with the state of the cache that led to it
+type EvictionDecision struct {
+	Key    string
+	Reason EvictionReason
+	Policy FullPolicy // the FullPolicy in effect
+
+	// Items and MemUsage are the size of the cache before the removal.
+	// Needed is what was left to free, counting this item: items over
+	// Capacity for EvictionCapacity, bytes over MemoryLimit for
+	// EvictionMemory, zero for EvictionExpired.
+	Items    int
+	MemUsage int64
+	Needed   int64
+
+	Size  int64   // bytes of the victim
+	Score float64 // key manager score of the victim, for key managers that score keys like decay
+	Fair  bool    // whether FairEviction picked the victim
+	Time  time.Time
+}
+
+// traceEviction records why k is about to be removed. Called under the
+// write lock, before k is deleted.
+func (p *cache) traceEviction(reason EvictionReason, k string, needed int64, fair bool) {
+	if !p.trace.enabled() {
+		return
+	}
+
+	item, found := p.items[k]
+	if !found {
+		return
+	}
+
+	d := EvictionDecision{
+		Key:      k,
+		Reason:   reason,
+		Policy:   p.option.FullPolicy,
+		Items:    len(p.items),
+		MemUsage: p.memUsage,
+		Needed:   needed,
+		Size:     item.Mem,
+		Fair:     fair,
+		Time:     time.Now(),
+	}
+	if scorer, ok := p.keyManager.(keymanager.Scorer); ok {
+		d.Score, _ = scorer.Score(k)
+	}
+
+	p.trace.push(d)
+}
+
+// EvictionTrace returns up to n of the most recent eviction decisions,
+// oldest first, or all the buffered ones if n <= 0. Only
+// Option.EvictionTraceSize decisions are kept; with no buffer it returns
+// nothing.
+func (p *cache) EvictionTrace(n int) []EvictionDecision {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.trace.last(n)
+}
@@ -0,0 +1,103 @@
+package cache
+
+import "time"
+
+// TTLRules maps key prefixes to the TTL of the keys under them, overriding
+// the one given to writes. A key follows the longest prefix it matches; use
+// NoExpiration to keep the keys under a prefix until evicted.
+type TTLRules map[string]time.Duration
+
+// SetTTLRules replaces the TTL rules for the writes that follow. With
+// retroactive, existing items under a rule get the expiration the rule
+// gives them counting from their last write, which may expire them right
+// away. Returns how many items were updated.
+func (p *cache) SetTTLRules(rules TTLRules, retroactive bool) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ttlRules = make(TTLRules, len(rules))
+	for prefix, ttl := range rules {
+		p.ttlRules[prefix] = ttl
+	}
+
+	if !retroactive || len(rules) == 0 {
+		return 0
+	}
+
+	updated := 0
+	for k, item := range p.items {
+		prefix, found := longestPrefix(p.ttlRules, k)
+		if !found {
+			continue
+		}
+
+		e := p.expiration(time.Unix(0, item.Updated), p.ttlRules[prefix])
+		if e != item.Expiration {
+			item.Expiration = e
+			p.scheduleExpiration(k, e)
+			updated++
+		}
+	}
+
+	return updated
+}
+
+// ttl is d, or the TTL of the rule k falls under
+func (p *cache) ttl(k string, d time.Duration) time.Duration {
+	if len(p.ttlRules) == 0 {
+		return d
+	}
+
+	if prefix, found := longestPrefix(p.ttlRules, k); found {
+		return p.ttlRules[prefix]
+	}
+
+	return d
+}
+
+// SetSoftTTLRules replaces the soft TTL rules, see Option.SoftTTLRules, for
+// the writes that follow. With retroactive, existing items under a rule get
+// the freshness the rule gives them counting from their last write. Returns
+// how many items were updated.
+func (p *cache) SetSoftTTLRules(rules TTLRules, retroactive bool) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.softTTLRules = make(TTLRules, len(rules))
+	for prefix, ttl := range rules {
+		p.softTTLRules[prefix] = ttl
+	}
+
+	if !retroactive || len(rules) == 0 {
+		return 0
+	}
+
+	updated := 0
+	for k, item := range p.items {
+		prefix, found := longestPrefix(p.softTTLRules, k)
+		if !found {
+			continue
+		}
+
+		fresh := p.freshness(time.Unix(0, item.Updated), p.softTTLRules[prefix], item.Expiration)
+		if fresh != item.Fresh {
+			item.Fresh = fresh
+			updated++
+		}
+	}
+
+	return updated
+}
+
+// softTTL is d, or the soft TTL of the rule k falls under if d is zero
+func (p *cache) softTTL(k string, d time.Duration) time.Duration {
+	if d > 0 || len(p.softTTLRules) == 0 {
+		return d
+	}
+
+	if prefix, found := longestPrefix(p.softTTLRules, k); found {
+		return p.softTTLRules[prefix]
+	}
+
+	return d
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"fmt"
+	"time"
+)
+
+// TypedCache is a Cache with keys of type K and values of type V, checked
+// at compile time. Keys are stored under their KeyString, so memory
+// accounting, policies and the key manager are those of the Cache.
+type TypedCache[K comparable, V any] struct {
+	cache *Cache
+}
+
+// NewTyped creates a TypedCache with the given options, see New
+func NewTyped[K comparable, V any](option *Option) (*TypedCache[K, V], error) {
+	c, err := New(option, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return Typed[K, V](c), nil
+}
+
+// Typed returns a TypedCache on top of c. Values of another type stored
+// in c under the same keys read as missing.
+func Typed[K comparable, V any](c *Cache) *TypedCache[K, V] {
+	return &TypedCache[K, V]{cache: c}
+}
+
+// KeyString is the key k is stored under: k itself for a string, its Go
+// syntax representation (%#v) otherwise
+func KeyString[K comparable](k K) string {
+	if s, ok := any(k).(string); ok {
+		return s
+	}
+
+	return fmt.Sprintf("%#v", k)
+}
+
+// Get returns the value of k and whether an unexpired value was found
+func (p *TypedCache[K, V]) Get(k K) (V, bool) {
+	return typed[V](p.cache.Get(KeyString(k)))
+}
+
+// Set stores v under k, see Cache.Set
+func (p *TypedCache[K, V]) Set(k K, v V, d time.Duration) error {
+	return p.cache.Set(KeyString(k), v, d)
+}
+
+// Add stores v under k only if k isn't set yet, see Cache.Add
+func (p *TypedCache[K, V]) Add(k K, v V, d time.Duration) error {
+	return p.cache.Add(KeyString(k), v, d)
+}
+
+// Replace stores v under k only if k is set already, see Cache.Replace
+func (p *TypedCache[K, V]) Replace(k K, v V, d time.Duration) error {
+	return p.cache.Replace(KeyString(k), v, d)
+}
+
+// Delete removes k
+func (p *TypedCache[K, V]) Delete(k K) {
+	p.cache.Delete(KeyString(k))
+}
+
+// GetAndDelete removes k and returns its value, see Cache.GetAndDelete
+func (p *TypedCache[K, V]) GetAndDelete(k K) (V, bool) {
+	return typed[V](p.cache.GetAndDelete(KeyString(k)))
+}
+
+// Size returns the number of items of the underlying Cache
+func (p *TypedCache[K, V]) Size() int {
+	return p.cache.Size()
+}
+
+func typed[V any](value interface{}, found bool) (V, bool) {
+	v, ok := value.(V)
+	return v, found && ok
+}
@@ -3,6 +3,9 @@ package cache
 import (
 	"math"
 	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
 )
 
 func IsPointer(v interface{}) (isPointer bool) {
@@ -14,74 +17,233 @@ func IsPointer(v interface{}) (isPointer bool) {
 }
 
 func DeepSize(v interface{}) int64 {
-	return int64(valueSize(reflect.ValueOf(v), make(map[uintptr]bool)))
+	s := newSizer(0)
+	s.measure(reflect.ValueOf(v))
+	return s.total
 }
 
-func valueSize(v reflect.Value, seen map[uintptr]bool) uintptr {
-	base := v.Type().Size()
+// parallelSizeThreshold is the length from which deepSizeLimit splits a
+// slice or a map across goroutines
+const parallelSizeThreshold = 4096
+
+// deepSizeLimit measures v like DeepSize, with two differences for big
+// values: a slice or map of parallelSizeThreshold elements or more is
+// measured by one goroutine per P, and once the running total goes over
+// limit (when limit > 0), however deep in v, measuring stops and false is
+// returned.
+func deepSizeLimit(v interface{}, limit int64) (int64, bool) {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return 0, true
+	}
+
+	s := newSizer(limit)
+	ok := s.measureTop(rv)
+	return atomic.LoadInt64(&s.total), ok
+}
+
+// sizer adds up the size of a value. Pointers already counted are kept in
+// seen, under mu once goroutines share it, so that each is counted once
+// whichever goroutine meets it first.
+type sizer struct {
+	limit int64
+	total int64 // updated atomically
+
+	mu   *sync.Mutex
+	seen map[uintptr]bool
+}
+
+func newSizer(limit int64) *sizer {
+	return &sizer{limit: limit, seen: make(map[uintptr]bool)}
+}
+
+// add counts n bytes and reports whether the total is still within limit
+func (s *sizer) add(n uintptr) bool {
+	total := atomic.AddInt64(&s.total, int64(n))
+	return s.limit <= 0 || total <= s.limit
+}
+
+// within reports whether the total is still within limit
+func (s *sizer) within() bool {
+	return s.limit <= 0 || atomic.LoadInt64(&s.total) <= s.limit
+}
+
+// visit reports whether p is met for the first time, marking it seen
+func (s *sizer) visit(p uintptr) bool {
+	if s.mu != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+
+	if s.seen[p] {
+		return false
+	}
+
+	s.seen[p] = true
+	return true
+}
+
+// measureTop is measure splitting a big slice or map across goroutines
+func (s *sizer) measureTop(v reflect.Value) bool {
+	switch {
+	case v.Kind() == reflect.Slice && v.Len() >= parallelSizeThreshold:
+		if !s.add(sliceOverhead(v)) {
+			return false
+		}
+
+		return s.measureParallel(v.Len(), func(i int) bool {
+			return s.measure(v.Index(i))
+		})
+
+	case v.Kind() == reflect.Map && v.Len() >= parallelSizeThreshold:
+		if !s.add(mapOverhead(v)) {
+			return false
+		}
+
+		keys := v.MapKeys()
+		return s.measureParallel(len(keys), func(i int) bool {
+			return s.measure(keys[i]) && s.measure(v.MapIndex(keys[i]))
+		})
+	}
+
+	return s.measure(v)
+}
+
+// measureParallel measures n elements with elem, split across one
+// goroutine per P, each stopping once the total goes over limit
+func (s *sizer) measureParallel(n int, elem func(i int) bool) bool {
+	s.mu = &sync.Mutex{}
+
+	workers := runtime.GOMAXPROCS(0)
+	chunk := (n + workers - 1) / workers
+
+	var wg sync.WaitGroup
+	for start := 0; start < n; start += chunk {
+		end := start + chunk
+		if end > n {
+			end = n
+		}
+
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+
+			for i := start; i < end; i++ {
+				if !elem(i) {
+					return
+				}
+			}
+		}(start, end)
+	}
+	wg.Wait()
+
+	return s.within()
+}
+
+// measure adds the size of v and reports whether the total is still within
+// limit, stopping as soon as it isn't
+func (s *sizer) measure(v reflect.Value) bool {
 	switch v.Kind() {
 	case reflect.Ptr:
-		p := v.Pointer()
-		if !seen[p] && !v.IsNil() {
-			seen[p] = true
-			return base + valueSize(v.Elem(), seen)
+		if !s.add(v.Type().Size()) {
+			return false
+		}
+
+		if !v.IsNil() && s.visit(v.Pointer()) {
+			return s.measure(v.Elem())
 		}
 
+		return true
+
 	case reflect.Slice:
-		n := v.Len()
-		for i := 0; i < n; i++ {
-			base += valueSize(v.Index(i), seen)
+		if !s.add(sliceOverhead(v)) {
+			return false
 		}
 
-		// Account for the parts of the array not covered by this slice.  Since
-		// we can't get the values directly, assume they're zeroes. That may be
-		// incorrect, in which case we may underestimate.
-		if cap := v.Cap(); cap > n {
-			base += v.Type().Size() * uintptr(cap-n)
+		for i := 0; i < v.Len(); i++ {
+			if !s.measure(v.Index(i)) {
+				return false
+			}
 		}
 
+		return true
+
 	case reflect.Map:
-		// A map m has len(m) / 6.5 buckets, rounded up to a power of two, and
-		// a minimum of one bucket. Each bucket is 16 bytes + 8*(keysize + valsize).
-		//
-		// We can't tell which keys are in which bucket by reflection, however,
-		// so here we count the 16-byte header for each bucket, and then just add
-		// in the computed key and value sizes.
-		nb := uintptr(math.Pow(2, math.Ceil(math.Log(float64(v.Len())/6.5)/math.Log(2))))
-		if nb == 0 {
-			nb = 1
+		if !s.add(mapOverhead(v)) {
+			return false
 		}
-		base = 16 * nb
-		for _, key := range v.MapKeys() {
-			base += valueSize(key, seen)
-			base += valueSize(v.MapIndex(key), seen)
+
+		iter := v.MapRange()
+		for iter.Next() {
+			if !s.measure(iter.Key()) || !s.measure(iter.Value()) {
+				return false
+			}
 		}
 
-		// We have nb buckets of 8 slots each, and v.Len() slots are filled.
-		// The remaining slots we will assume contain zero key/value pairs.
-		zk := v.Type().Key().Size()  // a zero key
-		zv := v.Type().Elem().Size() // a zero value
-		base += (8*nb - uintptr(v.Len())) * (zk + zv)
+		return true
 
 	case reflect.Struct:
+		if !s.add(v.Type().Size()) {
+			return false
+		}
+
 		// Chase pointer and slice fields and add the size of their members.
 		for i := 0; i < v.NumField(); i++ {
 			f := v.Field(i)
 			switch f.Kind() {
 			case reflect.Ptr:
-				p := f.Pointer()
-				if !seen[p] && !f.IsNil() {
-					seen[p] = true
-					base += valueSize(f.Elem(), seen)
+				if !f.IsNil() && s.visit(f.Pointer()) && !s.measure(f.Elem()) {
+					return false
 				}
 			case reflect.Slice:
-				base += valueSize(f, seen)
+				if !s.measure(f) {
+					return false
+				}
 			}
 		}
 
+		return true
+
 	case reflect.String:
-		return base + uintptr(v.Len())
+		return s.add(v.Type().Size() + uintptr(v.Len()))
+	}
+
+	return s.add(v.Type().Size())
+}
+
+// sliceOverhead is the size of a slice besides its elements
+func sliceOverhead(v reflect.Value) uintptr {
+	base := v.Type().Size()
+
+	// Account for the parts of the array not covered by this slice.  Since
+	// we can't get the values directly, assume they're zeroes. That may be
+	// incorrect, in which case we may underestimate.
+	if cap, n := v.Cap(), v.Len(); cap > n {
+		base += v.Type().Size() * uintptr(cap-n)
+	}
+
+	return base
+}
 
+// mapOverhead is the size of a map besides its keys and values
+func mapOverhead(v reflect.Value) uintptr {
+	// A map m has len(m) / 6.5 buckets, rounded up to a power of two, and
+	// a minimum of one bucket. Each bucket is 16 bytes + 8*(keysize + valsize).
+	//
+	// We can't tell which keys are in which bucket by reflection, however,
+	// so here we count the 16-byte header for each bucket, and then just add
+	// in the computed key and value sizes.
+	nb := uintptr(math.Pow(2, math.Ceil(math.Log(float64(v.Len())/6.5)/math.Log(2))))
+	if nb == 0 {
+		nb = 1
 	}
+	base := 16 * nb
+
+	// We have nb buckets of 8 slots each, and v.Len() slots are filled.
+	// The remaining slots we will assume contain zero key/value pairs.
+	zk := v.Type().Key().Size()  // a zero key
+	zv := v.Type().Elem().Size() // a zero value
+	base += (8*nb - uintptr(v.Len())) * (zk + zv)
+
 	return base
 }
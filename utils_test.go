@@ -1,6 +1,9 @@
 package cache
 
-import "testing"
+import (
+	"strconv"
+	"testing"
+)
 
 func TestCycles(t *testing.T) {
 	type V struct {
@@ -16,3 +19,51 @@ func TestCycles(t *testing.T) {
 		t.Errorf("Cyclic size: got %d, want %d", got, want)
 	}
 }
+
+func TestDeepSizeLimit(t *testing.T) {
+	values := make([]string, 10000, 12000)
+	for i := range values {
+		values[i] = strconv.Itoa(i)
+	}
+
+	got, ok := deepSizeLimit(values, 0)
+	if !ok || got != DeepSize(values) {
+		t.Errorf("Parallel size: got %d, want %d", got, DeepSize(values))
+	}
+
+	if _, ok := deepSizeLimit(values, 1000); ok {
+		t.Errorf("Limit: got ok over a limit of 1000 bytes")
+	}
+
+	if got, ok := deepSizeLimit(nil, 0); !ok || got != 0 {
+		t.Errorf("Nil size: got %d, want 0", got)
+	}
+
+	// Nested values and maps stop early too
+	nested := [][]string{values}
+	if got, ok := deepSizeLimit(nested, 1000); ok || got >= DeepSize(nested) {
+		t.Errorf("Nested limit: got %d, ok %v over a limit of 1000 bytes", got, ok)
+	}
+
+	m := make(map[string]string, len(values))
+	for _, v := range values {
+		m[v] = v
+	}
+	if got, ok := deepSizeLimit(m, 0); !ok || got != DeepSize(m) {
+		t.Errorf("Parallel map size: got %d, want %d", got, DeepSize(m))
+	}
+	if got, ok := deepSizeLimit(m, 1000); ok || got >= DeepSize(m) {
+		t.Errorf("Map limit: got %d, ok %v over a limit of 1000 bytes", got, ok)
+	}
+
+	// A pointer shared by elements is counted once
+	type big struct{ B [1024]byte }
+	shared := &big{}
+	pointers := make([]*big, 10000)
+	for i := range pointers {
+		pointers[i] = shared
+	}
+	if got, _ := deepSizeLimit(pointers, 0); got != DeepSize(pointers) {
+		t.Errorf("Shared pointer size: got %d, want %d", got, DeepSize(pointers))
+	}
+}
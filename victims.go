@@ -0,0 +1,82 @@
+package cache
+
+import "errors"
+
+// victimPicker hands evict its victims, skipping the detached keys being
+// written without taking them out of the key manager
+type victimPicker struct {
+	p        *cache
+	detached map[string]*Item
+
+	listed  bool     // order is used instead of the key manager
+	order   []string // the key manager's order, once a detached key came up
+	dropped []string // detached keys the key manager couldn't list past
+}
+
+func (p *cache) victims(detached map[string]*Item) *victimPicker {
+	return &victimPicker{p: p, detached: detached}
+}
+
+// next returns the next key to evict, from the items, and whether
+// FairEviction picked it. memory picks for MemoryLimit rather than Capacity.
+func (v *victimPicker) next(memory bool) (string, bool, error) {
+	p := v.p
+	for {
+		if v.listed {
+			if len(v.order) == 0 {
+				return "", false, ErrCacheFull
+			}
+
+			key := v.order[0]
+			v.order = v.order[1:]
+			if _, found := p.items[key]; found {
+				return key, false, nil
+			}
+
+			continue
+		}
+
+		var (
+			key  string
+			fair bool
+			err  error
+		)
+		if memory {
+			key, fair, err = p.memoryVictim()
+		} else {
+			key, err = p.nextVictim()
+		}
+		// The key manager ran out of keys: there is nothing left to evict
+		if err != nil {
+			return "", false, ErrCacheFull
+		}
+
+		if key == "" {
+			return "", false, errors.New("invalid key")
+		}
+
+		if _, found := v.detached[key]; found {
+			if lister, ok := p.keyManager.(keyLister); ok {
+				v.order = append(p.untrackedKeys(), lister.GetValues()...)
+				v.listed = true
+				continue
+			}
+
+			v.dropped = append(v.dropped, key)
+		}
+
+		if _, found := p.items[key]; !found {
+			p.keyManager.Delete(key)
+			continue
+		}
+
+		return key, fair, nil
+	}
+}
+
+// restore tracks again the detached keys next had to drop
+func (v *victimPicker) restore() {
+	for _, k := range v.dropped {
+		v.p.trackKey(k)
+	}
+}
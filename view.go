@@ -0,0 +1,42 @@
+package cache
+
+import "time"
+
+// TypedView is a typed window on the keys of a Cache under a prefix. It
+// shares the cache's memory limit, policies and janitor; pair the prefix
+// with a Quota to give it a budget of its own.
+type TypedView[T any] struct {
+	cache  *Cache
+	prefix string
+}
+
+// View returns the TypedView of c for the keys under prefix
+func View[T any](c *Cache, prefix string) TypedView[T] {
+	return TypedView[T]{
+		cache:  c,
+		prefix: prefix,
+	}
+}
+
+// Get returns the value of k and whether an unexpired value of type T was
+// found under the view's prefix
+func (v TypedView[T]) Get(k string) (T, bool) {
+	value, found := v.cache.Get(v.prefix + k)
+	if !found {
+		var zero T
+		return zero, false
+	}
+
+	typed, ok := value.(T)
+	return typed, ok
+}
+
+// Set stores value under the view's prefix, see Cache.Set
+func (v TypedView[T]) Set(k string, value T, d time.Duration) error {
+	return v.cache.Set(v.prefix+k, value, d)
+}
+
+// Delete removes k from under the view's prefix
+func (v TypedView[T]) Delete(k string) {
+	v.cache.Delete(v.prefix + k)
+}